@@ -0,0 +1,370 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+const (
+	// AugmentPrefix is the Example feature key prefix for augmentation tags:
+	// image/augment/source_id, image/augment/index and image/augment/transform
+	AugmentPrefix = "image/augment/"
+)
+
+// AugmentOptions are the options for generating random augmentations. The
+// augmentations of an image depend only on Seed, the image ID and the copy
+// number so builds are reproducible.
+type AugmentOptions struct {
+	// Seed for the random augmentations
+	Seed int64
+
+	// Number of augmented copies of each image
+	Copies int
+
+	// Number of augmented copies by label ID. Overrides Copies for images
+	// with these labels
+	Multiplier map[int]int
+
+	// Randomly flip images horizontally and vertically
+	FlipH bool
+	FlipV bool
+
+	// Randomly rotate images by a multiple of 90 degrees
+	Rotate bool
+
+	// Maximum brightness shift as a fraction of the intensity range (0-1)
+	Brightness float64
+
+	// Maximum relative contrast change. The contrast is scaled by a factor in
+	// [1-Contrast, 1+Contrast]
+	Contrast float64
+
+	// Minimum fraction (0-1] of the width and height kept by random crops. 0
+	// disables cropping
+	Crop float64
+}
+
+// Augmentation is a single random augmentation. Crops are applied first,
+// followed by flips, the clockwise rotation and the brightness and contrast
+// jitter.
+type Augmentation struct {
+	// Crop region in normalized coordinates. A zero width keeps the whole
+	// image
+	CropX float64
+	CropY float64
+	CropW float64
+	CropH float64
+
+	FlipH bool
+	FlipV bool
+
+	// Clockwise rotation in degrees (0, 90, 180 or 270)
+	Rotate int
+
+	// Brightness shift as a fraction of the intensity range
+	Brightness float64
+
+	// Contrast scale factor
+	Contrast float64
+}
+
+// AugmentInfo tags an augmented image
+type AugmentInfo struct {
+	// ID of the source image
+	SourceID int
+
+	// Copy number starting at 1
+	Index int
+
+	// Description of the augmentation, see Augmentation.String
+	Transform string
+}
+
+// Enabled returns true if any augmentation is enabled in AugmentOptions o
+func (o *AugmentOptions) Enabled() bool {
+	return o.FlipH || o.FlipV || o.Rotate || o.Brightness > 0 || o.Contrast > 0 || o.Crop > 0
+}
+
+// CopiesFor returns the number of augmented copies for images with label ID
+// label
+func (o *AugmentOptions) CopiesFor(label int) int {
+	if n, ok := o.Multiplier[label]; ok {
+		return n
+	}
+
+	return o.Copies
+}
+
+// Sample returns the random augmentation for copy n of the image with the
+// given id
+func (o *AugmentOptions) Sample(id, n int) *Augmentation {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, []int64{o.Seed, int64(id), int64(n)})
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	a := &Augmentation{Contrast: 1}
+
+	if o.Crop > 0 && o.Crop < 1 {
+		scale := o.Crop + rng.Float64()*(1-o.Crop)
+		a.CropW, a.CropH = scale, scale
+		a.CropX = rng.Float64() * (1 - scale)
+		a.CropY = rng.Float64() * (1 - scale)
+	}
+	if o.FlipH {
+		a.FlipH = rng.Intn(2) == 1
+	}
+	if o.FlipV {
+		a.FlipV = rng.Intn(2) == 1
+	}
+	if o.Rotate {
+		a.Rotate = 90 * rng.Intn(4)
+	}
+	if o.Brightness > 0 {
+		a.Brightness = (2*rng.Float64() - 1) * o.Brightness
+	}
+	if o.Contrast > 0 {
+		a.Contrast = 1 + (2*rng.Float64()-1)*o.Contrast
+	}
+
+	return a
+}
+
+// String returns a description of Augmentation a as semicolon separated
+// operations in the order they are applied, for example
+// "crop=0.050,0.100,0.900,0.900;fliph;rot90;brightness=-0.020;contrast=1.100"
+func (a *Augmentation) String() string {
+	ops := make([]string, 0)
+	if a.CropW > 0 {
+		ops = append(ops, fmt.Sprintf("crop=%.3f,%.3f,%.3f,%.3f", a.CropX, a.CropY, a.CropW, a.CropH))
+	}
+	if a.FlipH {
+		ops = append(ops, "fliph")
+	}
+	if a.FlipV {
+		ops = append(ops, "flipv")
+	}
+	if a.Rotate != 0 {
+		ops = append(ops, fmt.Sprintf("rot%d", a.Rotate))
+	}
+	if a.Brightness != 0 {
+		ops = append(ops, fmt.Sprintf("brightness=%.3f", a.Brightness))
+	}
+	if a.Contrast != 0 && a.Contrast != 1 {
+		ops = append(ops, fmt.Sprintf("contrast=%.3f", a.Contrast))
+	}
+
+	if len(ops) == 0 {
+		return "none"
+	}
+
+	return strings.Join(ops, ";")
+}
+
+// geometry returns the Transform for the crop, flips and rotation of
+// Augmentation a. Pixels are copied exactly so it can be applied to masks.
+func (a *Augmentation) geometry() Transform {
+	return TransformFunc(func(im image.Image) (image.Image, error) {
+		var err error
+
+		if a.CropW > 0 {
+			b := im.Bounds()
+			w, h := float64(b.Dx()), float64(b.Dy())
+			r := image.Rect(
+				int(math.Round(a.CropX*w)), int(math.Round(a.CropY*h)),
+				int(math.Round((a.CropX+a.CropW)*w)), int(math.Round((a.CropY+a.CropH)*h)))
+			im, err = Crop(r).Apply(im)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Flips and rotations are EXIF orientations
+		orientations := make([]int, 0)
+		if a.FlipH {
+			orientations = append(orientations, 2)
+		}
+		if a.FlipV {
+			orientations = append(orientations, 4)
+		}
+		switch a.Rotate {
+		case 90:
+			orientations = append(orientations, 6)
+		case 180:
+			orientations = append(orientations, 3)
+		case 270:
+			orientations = append(orientations, 8)
+		}
+
+		for _, o := range orientations {
+			im, err = Orient(o).Apply(im)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return im, nil
+	})
+}
+
+// Apply applies Augmentation a to the pixels of im
+func (a *Augmentation) Apply(im image.Image) (image.Image, error) {
+	im, err := a.geometry().Apply(im)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Brightness == 0 && (a.Contrast == 0 || a.Contrast == 1) {
+		return im, nil
+	}
+
+	return Jitter(a.Brightness, a.Contrast).Apply(im)
+}
+
+// Mask applies the crop, flips and rotation of Augmentation a to the mask m
+func (a *Augmentation) Mask(m image.Image) (image.Image, error) {
+	return a.geometry().Apply(m)
+}
+
+// Boxes returns the bounding boxes after the crop, flips and rotation of
+// Augmentation a. Boxes are normalized so the image size is not used.
+func (a *Augmentation) Boxes(boxes []BBox, w, h int) ([]BBox, []int, error) {
+	boxes, kept := a.boxes(boxes)
+	return boxes, kept, nil
+}
+
+// boxes returns the bounding boxes after the crop, flips and rotation of
+// Augmentation a
+func (a *Augmentation) boxes(boxes []BBox) ([]BBox, []int) {
+	kept := make([]int, len(boxes))
+	for n := range kept {
+		kept[n] = n
+	}
+
+	if a.CropW > 0 {
+		boxes, kept = clipBoxes(boxes, a.CropX, a.CropY, a.CropX+a.CropW, a.CropY+a.CropH, a.CropW, a.CropH)
+	}
+
+	out := make([]BBox, len(boxes))
+	for n, b := range boxes {
+		if a.FlipH {
+			b.XMin, b.XMax = 1-b.XMax, 1-b.XMin
+		}
+		if a.FlipV {
+			b.YMin, b.YMax = 1-b.YMax, 1-b.YMin
+		}
+		for r := 0; r < a.Rotate; r += 90 {
+			b.XMin, b.YMin, b.XMax, b.YMax = 1-b.YMax, b.XMin, 1-b.YMin, b.XMax
+		}
+		out[n] = b
+	}
+
+	return out, kept
+}
+
+// Jitter returns a Transform that shifts the brightness of an image by
+// brightness (a fraction of the intensity range) and scales the contrast
+// around the mid intensity by contrast. Alpha is preserved.
+func Jitter(brightness, contrast float64) Transform {
+	return TransformFunc(func(im image.Image) (image.Image, error) {
+		adjust := func(v uint32) uint16 {
+			f := (float64(v)/0xffff-0.5)*contrast + 0.5 + brightness
+			return uint16(math.Round(math.Max(0, math.Min(1, f)) * 0xffff))
+		}
+
+		b := im.Bounds()
+		r := image.Rect(0, 0, b.Dx(), b.Dy())
+
+		var dst draw.Image
+		switch im.(type) {
+		case *image.Gray:
+			dst = image.NewGray(r)
+		case *image.Gray16:
+			dst = image.NewGray16(r)
+		case *image.RGBA64, *image.NRGBA64:
+			dst = image.NewNRGBA64(r)
+		default:
+			dst = image.NewNRGBA(r)
+		}
+
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := color.NRGBA64Model.Convert(im.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+				c.R = adjust(uint32(c.R))
+				c.G = adjust(uint32(c.G))
+				c.B = adjust(uint32(c.B))
+				dst.Set(x, y, c)
+			}
+		}
+
+		return dst, nil
+	})
+}
+
+// Augment returns copy n of Image i tagged with augmentation a and the
+// Transform that applies a to the decoded image. The pixel data, objects and
+// masks are not changed until the copy is converted with the returned
+// Transform, which crops, flips and rotates them with the image and drops
+// objects outside the crop with their instance masks, see Convert and
+// Tiles. The copy does not share labels, objects or Extra features with i.
+// It keeps the ID and Filename of i and is told apart by Augmented (the
+// image/augment/index feature) and Name, for example 7_aug2.jpeg. Key and
+// Hashes describe the source image and are cleared.
+func (i *Image) Augment(a *Augmentation, n int) (*Image, Transform, error) {
+	aug := i.clone()
+	aug.Augmented = &AugmentInfo{SourceID: i.ID, Index: n, Transform: a.String()}
+	aug.Key = ""
+	aug.Hashes = nil
+
+	return aug, a, nil
+}
+
+// marshalAugment adds the augmentation features of Image i to features
+func (i *Image) marshalAugment(features map[string]*protobuf.Feature) {
+	if i.Augmented == nil {
+		return
+	}
+
+	features[AugmentPrefix+"source_id"] = Int64Feature(int64(i.Augmented.SourceID))
+	features[AugmentPrefix+"index"] = Int64Feature(int64(i.Augmented.Index))
+	features[AugmentPrefix+"transform"] = BytesFeature([]byte(i.Augmented.Transform))
+}
+
+// unmarshalAugment decodes the augmentation features of example into Image i
+func (i *Image) unmarshalAugment(example *protobuf.Example) {
+	if _, ok := example.Features.Feature[AugmentPrefix+"transform"]; !ok {
+		i.Augmented = nil
+		return
+	}
+
+	i.Augmented = &AugmentInfo{
+		SourceID:  ExampleFeatureInt64(example, AugmentPrefix+"source_id"),
+		Index:     ExampleFeatureInt64(example, AugmentPrefix+"index"),
+		Transform: string(ExampleFeatureBytes(example, AugmentPrefix+"transform")),
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+func TestAugmentSample(t *testing.T) {
+	opts := &AugmentOptions{Seed: 42, FlipH: true, Rotate: true, Brightness: 0.2, Contrast: 0.2, Crop: 0.8}
+
+	a := opts.Sample(7, 1)
+	if b := opts.Sample(7, 1); *a != *b {
+		t.Errorf("Expected identical augmentations: got %s and %s", a, b)
+	}
+
+	if a.CropW < 0.8 || a.CropW > 1 || a.CropX+a.CropW > 1 || math.Abs(a.Brightness) > 0.2 || math.Abs(a.Contrast-1) > 0.2 {
+		t.Errorf("Augmentation out of range: %s", a)
+	}
+
+	opts.Seed = 43
+	if b := opts.Sample(7, 1); *a == *b {
+		t.Errorf("Expected different augmentations for different seeds: got %s", b)
+	}
+}
+
+func TestAugmentBoxes(t *testing.T) {
+	boxes := []BBox{{XMin: 0.1, YMin: 0.2, XMax: 0.3, YMax: 0.4}, {XMin: 0.8, YMin: 0.8, XMax: 0.9, YMax: 0.9}}
+
+	a := &Augmentation{FlipH: true, Rotate: 90}
+	got, _ := a.boxes(boxes)
+	expected := BBox{XMin: 0.6, YMin: 0.7, XMax: 0.8, YMax: 0.9}
+	if d := math.Abs(got[0].XMin-expected.XMin) + math.Abs(got[0].YMin-expected.YMin) + math.Abs(got[0].XMax-expected.XMax) + math.Abs(got[0].YMax-expected.YMax); d > 1e-9 {
+		t.Errorf("Incorrect box: got %+v should be %+v", got[0], expected)
+	}
+
+	a = &Augmentation{CropX: 0, CropY: 0, CropW: 0.5, CropH: 0.5}
+	got, kept := a.boxes(boxes)
+	if len(got) != 1 || kept[0] != 0 || math.Abs(got[0].XMax-0.6) > 1e-9 {
+		t.Errorf("Incorrect cropped boxes: got %+v", got)
+	}
+}
+
+func TestAugment(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 7, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im.Labels = []Label{{ID: 1, Text: "Crystal"}}
+	im.Extra = map[string]*protobuf.Feature{}
+
+	a := &Augmentation{Rotate: 90, Contrast: 1, Brightness: 0.1}
+	aug, tr, err := im.Augment(a, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = aug.Convert(ConvertNone, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aug.Width != 103 || aug.Height != 150 {
+		t.Errorf("Incorrect size: got %dx%d should be %dx%d", aug.Width, aug.Height, 103, 150)
+	}
+	if im.Width != 150 {
+		t.Errorf("Source image changed")
+	}
+
+	aug.Labels[0].Text = "Clear"
+	aug.Extra["test"] = nil
+	if im.Labels[0].Text != "Crystal" || len(im.Extra) != 0 {
+		t.Errorf("Source labels or extra features changed")
+	}
+
+	ex, err := aug.MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := &Image{}
+	if err := img.UnmarshalExample(ex); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := AugmentInfo{SourceID: 7, Index: 2, Transform: "rot90;brightness=0.100"}
+	if img.Augmented == nil || *img.Augmented != expected {
+		t.Errorf("Incorrect augmentation: got %+v should be %+v", img.Augmented, expected)
+	}
+	if name := img.Name(); name != "7_aug2.jpeg" {
+		t.Errorf("Incorrect name: got %s should be %s", name, "7_aug2.jpeg")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(0, 0, color.Gray{Y: 64})
+	src.SetGray(1, 0, color.Gray{Y: 255})
+
+	im, err := Jitter(0.25, 1).Apply(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gray, ok := im.(*image.Gray)
+	if !ok {
+		t.Fatalf("Expected grayscale image")
+	}
+
+	if v := gray.GrayAt(0, 0).Y; v != 128 {
+		t.Errorf("Incorrect brightness: got %d should be %d", v, 128)
+	}
+	if v := gray.GrayAt(1, 0).Y; v != 255 {
+		t.Errorf("Incorrect clipped brightness: got %d should be %d", v, 255)
+	}
+}
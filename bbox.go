@@ -0,0 +1,301 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// BBox is an object bounding box with its class. Coordinates are normalized
+// to [0, 1] relative to the top left corner of the upright image following the
+// TensorFlow Object Detection API, so they remain valid when the image is
+// resized.
+type BBox struct {
+	XMin float64
+	YMin float64
+	XMax float64
+	YMax float64
+
+	// Integer ID for the object class
+	LabelID int
+
+	// The human-readable version of the object class
+	LabelText string
+
+	// Object is difficult to recognize
+	Difficult bool
+
+	// Object extends beyond the image or is occluded
+	Truncated bool
+}
+
+// ParseBBoxes parses bounding boxes in pixel coordinates of a w x h image
+// from the string val. Boxes are separated by semicolons and each box is in
+// the form:
+//
+//  xmin,ymin,xmax,ymax,label_id[,label_text[,difficult[,truncated]]]
+//
+// where difficult and truncated are 0 or 1. Label text can not contain commas
+// or semicolons. An empty string returns no boxes.
+func ParseBBoxes(val string, w, h int) ([]BBox, error) {
+	boxes := make([]BBox, 0)
+	if len(strings.TrimSpace(val)) == 0 {
+		return boxes, nil
+	}
+
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("Invalid image size for bounding boxes")
+	}
+
+	for _, spec := range strings.Split(val, ";") {
+		parts := strings.Split(spec, ",")
+		if len(parts) < 5 || len(parts) > 8 {
+			return nil, fmt.Errorf("Invalid bounding box: %s", spec)
+		}
+
+		coords := make([]float64, 4)
+		for idx := range coords {
+			n, err := strconv.ParseFloat(strings.TrimSpace(parts[idx]), 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				return nil, fmt.Errorf("Invalid bounding box: %s", spec)
+			}
+			coords[idx] = n
+		}
+
+		if coords[0] < 0 || coords[1] < 0 || coords[0] >= coords[2] || coords[1] >= coords[3] || coords[2] > float64(w) || coords[3] > float64(h) {
+			return nil, fmt.Errorf("Bounding box outside of %dx%d image: %s", w, h, spec)
+		}
+
+		label, err := strconv.Atoi(strings.TrimSpace(parts[4]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bounding box label: %s", spec)
+		}
+
+		box := BBox{
+			XMin:    coords[0] / float64(w),
+			YMin:    coords[1] / float64(h),
+			XMax:    coords[2] / float64(w),
+			YMax:    coords[3] / float64(h),
+			LabelID: label,
+		}
+
+		if len(parts) > 5 {
+			box.LabelText = strings.TrimSpace(parts[5])
+		}
+
+		flags := []*bool{&box.Difficult, &box.Truncated}
+		for idx := 6; idx < len(parts); idx++ {
+			*flags[idx-6], err = strconv.ParseBool(strings.TrimSpace(parts[idx]))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid bounding box flag: %s", spec)
+			}
+		}
+
+		boxes = append(boxes, box)
+	}
+
+	return boxes, nil
+}
+
+// FormatBBoxes formats the bounding boxes in pixel coordinates of a w x h
+// image. This is the inverse of ParseBBoxes.
+func FormatBBoxes(boxes []BBox, w, h int) string {
+	specs := make([]string, len(boxes))
+	for idx, b := range boxes {
+		parts := []string{
+			pixelString(b.XMin, w),
+			pixelString(b.YMin, h),
+			pixelString(b.XMax, w),
+			pixelString(b.YMax, h),
+			strconv.Itoa(b.LabelID),
+			b.LabelText,
+		}
+		if b.Difficult || b.Truncated {
+			parts = append(parts, boolString(b.Difficult), boolString(b.Truncated))
+		}
+		specs[idx] = strings.Join(parts, ",")
+	}
+
+	return strings.Join(specs, ";")
+}
+
+// pixelString returns the normalized coordinate v in pixels of an image
+// dimension size rounded to two decimal places
+func pixelString(v float64, size int) string {
+	return strconv.FormatFloat(math.Round(v*float64(size)*100)/100, 'f', -1, 64)
+}
+
+// boolString returns "1" if b is true otherwise "0"
+func boolString(b bool) string {
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
+
+// clipBoxes clips boxes to the normalized region (x0, y0) - (x1, y1) and
+// rescales them relative to a region of normalized width sx and height sy
+// with its top left corner at (x0, y0). sx and sy can be larger than the
+// clipped region when it is padded. Boxes outside the region are dropped and
+// clipped boxes are marked truncated. The indexes of the kept boxes are
+// returned with the boxes.
+func clipBoxes(boxes []BBox, x0, y0, x1, y1, sx, sy float64) ([]BBox, []int) {
+	var clipped []BBox
+	var kept []int
+
+	for n, b := range boxes {
+		c := b
+		c.XMin = math.Max(b.XMin, x0)
+		c.YMin = math.Max(b.YMin, y0)
+		c.XMax = math.Min(b.XMax, x1)
+		c.YMax = math.Min(b.YMax, y1)
+		if c.XMin >= c.XMax || c.YMin >= c.YMax {
+			continue
+		}
+
+		if c.XMin > b.XMin || c.YMin > b.YMin || c.XMax < b.XMax || c.YMax < b.YMax {
+			c.Truncated = true
+		}
+
+		c.XMin = (c.XMin - x0) / sx
+		c.YMin = (c.YMin - y0) / sy
+		c.XMax = (c.XMax - x0) / sx
+		c.YMax = (c.YMax - y0) / sy
+
+		clipped = append(clipped, c)
+		kept = append(kept, n)
+	}
+
+	return clipped, kept
+}
+
+// objectKey returns the Example feature key for the bounding box field name
+// or an empty string if profile p does not encode bounding boxes
+func (p *Profile) objectKey(name string) string {
+	if len(p.Object) == 0 {
+		return ""
+	}
+
+	return p.Object + "/" + name
+}
+
+// marshalObjects adds the bounding box features for boxes to features using
+// the keys of profile p. Nothing is added if there are no boxes.
+func marshalObjects(features map[string]*protobuf.Feature, p *Profile, boxes []BBox) {
+	if len(boxes) == 0 || len(p.Object) == 0 {
+		return
+	}
+
+	xmin := make([]float32, len(boxes))
+	ymin := make([]float32, len(boxes))
+	xmax := make([]float32, len(boxes))
+	ymax := make([]float32, len(boxes))
+	label := make([]int64, len(boxes))
+	text := make([][]byte, len(boxes))
+	difficult := make([]int64, len(boxes))
+	truncated := make([]int64, len(boxes))
+
+	for idx, b := range boxes {
+		xmin[idx] = float32(b.XMin)
+		ymin[idx] = float32(b.YMin)
+		xmax[idx] = float32(b.XMax)
+		ymax[idx] = float32(b.YMax)
+		label[idx] = int64(b.LabelID)
+		text[idx] = []byte(b.LabelText)
+		if b.Difficult {
+			difficult[idx] = 1
+		}
+		if b.Truncated {
+			truncated[idx] = 1
+		}
+	}
+
+	features[p.objectKey("bbox/xmin")] = FloatListFeature(xmin)
+	features[p.objectKey("bbox/ymin")] = FloatListFeature(ymin)
+	features[p.objectKey("bbox/xmax")] = FloatListFeature(xmax)
+	features[p.objectKey("bbox/ymax")] = FloatListFeature(ymax)
+	features[p.objectKey("class/label")] = Int64ListFeature(label)
+	features[p.objectKey("class/text")] = BytesListFeature(text)
+	features[p.objectKey("difficult")] = Int64ListFeature(difficult)
+	features[p.objectKey("truncated")] = Int64ListFeature(truncated)
+}
+
+// ExampleObjects decodes the bounding boxes from a TensorFlow Example using
+// the keys of profile p. The class, difficult and truncated features are
+// optional but must have one value per box if present.
+func ExampleObjects(example *protobuf.Example, p *Profile) ([]BBox, error) {
+	if len(p.Object) == 0 {
+		return nil, nil
+	}
+
+	xmin := ExampleFeatureFloatList(example, p.objectKey("bbox/xmin"))
+	ymin := ExampleFeatureFloatList(example, p.objectKey("bbox/ymin"))
+	xmax := ExampleFeatureFloatList(example, p.objectKey("bbox/xmax"))
+	ymax := ExampleFeatureFloatList(example, p.objectKey("bbox/ymax"))
+	label := ExampleFeatureInt64List(example, p.objectKey("class/label"))
+	text := ExampleFeatureBytesList(example, p.objectKey("class/text"))
+	difficult := ExampleFeatureInt64List(example, p.objectKey("difficult"))
+	truncated := ExampleFeatureInt64List(example, p.objectKey("truncated"))
+
+	n := len(xmin)
+	if n == 0 {
+		return nil, nil
+	}
+
+	if len(ymin) != n || len(xmax) != n || len(ymax) != n {
+		return nil, errors.New("Invalid bounding box features: coordinate counts differ")
+	}
+
+	for _, count := range []int{len(label), len(text), len(difficult), len(truncated)} {
+		if count != 0 && count != n {
+			return nil, errors.New("Invalid bounding box features: class counts differ")
+		}
+	}
+
+	boxes := make([]BBox, n)
+	for idx := range boxes {
+		b := BBox{
+			XMin: float64(xmin[idx]),
+			YMin: float64(ymin[idx]),
+			XMax: float64(xmax[idx]),
+			YMax: float64(ymax[idx]),
+		}
+		if len(label) > 0 {
+			b.LabelID = int(label[idx])
+		}
+		if len(text) > 0 {
+			b.LabelText = string(text[idx])
+		}
+		if len(difficult) > 0 {
+			b.Difficult = difficult[idx] != 0
+		}
+		if len(truncated) > 0 {
+			b.Truncated = truncated[idx] != 0
+		}
+		boxes[idx] = b
+	}
+
+	return boxes, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseBBoxes(t *testing.T) {
+	boxes, err := ParseBBoxes("10,20,50,60,1,Crystal;0,0,200,100,2,Precipitate,1,0;5,5,6,6,3", 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(boxes) != 3 {
+		t.Fatalf("Incorrect number of boxes: got %d should be %d", len(boxes), 3)
+	}
+
+	b := boxes[0]
+	if b.XMin != 0.05 || b.YMin != 0.2 || b.XMax != 0.25 || b.YMax != 0.6 || b.LabelID != 1 || b.LabelText != "Crystal" {
+		t.Errorf("Invalid box: got %+v", b)
+	}
+	if !boxes[1].Difficult || boxes[1].Truncated {
+		t.Errorf("Invalid box flags: got %+v", boxes[1])
+	}
+
+	spec := FormatBBoxes(boxes, 200, 100)
+	if spec != "10,20,50,60,1,Crystal;0,0,200,100,2,Precipitate,1,0;5,5,6,6,3," {
+		t.Errorf("Invalid format: got %s", spec)
+	}
+
+	for _, bad := range []string{"10,20,50", "50,20,10,60,1", "10,20,250,60,1", "10,20,50,60,x", "NaN,1,2,3,1,x", "1,1,2,NaN,1", "1,1,Inf,3,1"} {
+		if _, err := ParseBBoxes(bad, 200, 100); err == nil {
+			t.Errorf("Expected error for invalid box %s", bad)
+		}
+	}
+}
+
+func TestObjectsRoundTrip(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	for _, p := range []*Profile{InceptionProfile, ObjectDetectionProfile} {
+		im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im.Profile = p
+		im.Objects = []BBox{
+			{XMin: 0.25, YMin: 0.5, XMax: 0.75, YMax: 1, LabelID: 3, LabelText: "Crystal", Truncated: true},
+			{XMin: 0, YMin: 0, XMax: 0.5, YMax: 0.5, LabelID: 4, LabelText: "Skin"},
+		}
+
+		ex, err := im.MarshalExample()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := ExampleFeatureFloatList(ex, "image/object/bbox/xmin"); len(got) != 2 || got[0] != 0.25 {
+			t.Errorf("%s: Invalid image/object/bbox/xmin: got %v", p.Name, got)
+		}
+
+		im2 := &Image{Profile: p}
+		if err := im2.UnmarshalExample(ex); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(im2.Objects) != len(im.Objects) {
+			t.Fatalf("%s: Incorrect number of boxes: got %d should be %d", p.Name, len(im2.Objects), len(im.Objects))
+		}
+		for idx := range im.Objects {
+			if im2.Objects[idx] != im.Objects[idx] {
+				t.Errorf("%s: Invalid box: got %+v should be %+v", p.Name, im2.Objects[idx], im.Objects[idx])
+			}
+		}
+	}
+}
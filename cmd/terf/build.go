@@ -18,256 +18,372 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/zlib"
 	"context"
-	"encoding/csv"
 	"errors"
 	"fmt"
-	"io"
-	"math"
 	"os"
-	"path/filepath"
-	"runtime"
+	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/ubccr/terf"
-	"golang.org/x/sync/errgroup"
+	"github.com/ubccr/terf/dataset"
+	"github.com/urfave/cli"
 )
 
-type Shard struct {
-	BaseDir  string
-	Name     string
-	ID       int
-	Total    int
-	Compress bool
-	Records  [][]string
-}
+// Build converts the images listed in the CSV file infile into sharded
+// TFRecords files. Rejected rows are written to the CSV file rejects if set
+func Build(ctx context.Context, infile, rejects string, opts *dataset.BuildOptions, imageOpts *dataset.ImageOptions) error {
+	in, err := os.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	src, err := dataset.NewImageSource(in, imageOpts)
+	if err != nil {
+		return err
+	}
 
-func (s *Shard) Next() *Shard {
-	return &Shard{
-		BaseDir:  s.BaseDir,
-		Name:     s.Name,
-		Total:    s.Total,
-		ID:       s.ID + 1,
-		Compress: s.Compress,
-		Records:  make([][]string, 0),
+	res, err := dataset.Build(ctx, src, opts)
+	if err != nil {
+		return err
 	}
-}
 
-func lineCounter(r io.Reader) (int, error) {
-	reader := bufio.NewReader(r)
-	buf := make([]byte, 32*1024)
-	count := 0
-	lineSep := []byte{'\n'}
-
-	for {
-		c, err := reader.Read(buf)
-		count += bytes.Count(buf[:c], lineSep)
-
-		switch {
-		case err == io.EOF:
-			// Skip required header row
-			count--
-			if count <= 0 {
-				return count, errors.New("No lines found")
-			}
+	log.WithFields(log.Fields{
+		"shards":  len(res.Shards),
+		"records": res.Total,
+		"rejects": len(res.Rejects),
+	}).Info("Build complete")
 
-			return count, nil
-		case err != nil:
-			return count, err
+	if len(rejects) > 0 {
+		out, err := os.Create(rejects)
+		if err != nil {
+			return err
 		}
-	}
 
-}
+		err = dataset.WriteRejects(out, src.Header(), res.Rejects)
+		if err != nil {
+			out.Close()
+			return err
+		}
 
-func Build(infile, outdir, name string, numPerBatch, threads int, compress, jpeg bool) error {
-	if len(outdir) == 0 {
-		cwd, err := os.Getwd()
+		err = out.Close()
 		if err != nil {
 			return err
 		}
-		outdir = cwd
 	}
 
-	err := os.MkdirAll(outdir, 0755)
+	if len(res.Rejects) > 0 {
+		log.Warnf("Rejected %d image(s)", len(res.Rejects))
+	}
+
+	return nil
+}
+
+// buildOptions returns the dataset build and image options for the build
+// command line options
+func buildOptions(c *cli.Context) (*dataset.BuildOptions, *dataset.ImageOptions, error) {
+	profile, err := terf.ParseProfile(c.String("profile"))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if threads == 0 {
-		threads = runtime.NumCPU()
+	transforms, err := buildTransforms(c)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if len(name) == 0 {
-		name = "train"
+	opts := &dataset.BuildOptions{
+		OutDir:    c.String("outdir"),
+		Name:      c.String("name"),
+		Size:      c.Int("size"),
+		Threads:   c.Int("threads"),
+		Compress:  c.Bool("compress"),
+		KeepGoing: c.Bool("keep-going"),
+		Progress:  logProgress,
 	}
 
-	if numPerBatch == 0 {
-		numPerBatch = 1024
+	quality := c.Int("jpeg-quality")
+	if quality < 0 || quality > 100 {
+		return nil, nil, errors.New("jpeg-quality must be between 0 and 100 (0 uses the default)")
 	}
 
-	in, err := os.Open(infile)
+	convert, err := terf.ParseConversion(c.String("convert"))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	if c.Bool("jpeg") {
+		if convert != terf.ConvertNone && convert != terf.ConvertRGB {
+			return nil, nil, errors.New("Only one of jpeg or convert can be set")
+		}
+		convert = terf.ConvertRGB
 	}
-	defer in.Close()
 
-	total, err := lineCounter(in)
+	hashes := make([]string, 0)
+	if len(c.String("hash")) > 0 {
+		for _, name := range strings.Split(c.String("hash"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, err := terf.ParseHash(name); err != nil {
+				return nil, nil, err
+			}
+			hashes = append(hashes, name)
+		}
+	}
+
+	pixels, err := terf.ParsePixelEncoding(c.String("pixel-encoding"))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if len(c.String("pixel-encoding")) > 0 && convert != terf.ConvertRaw {
+		return nil, nil, errors.New("pixel-encoding requires convert raw")
 	}
 
-	if numPerBatch > total {
-		total = 1
-	} else {
-		total = int(math.Ceil(float64(total) / float64(numPerBatch)))
+	var pattern *terf.FilenamePattern
+	if expr := c.String("filename-pattern"); len(expr) > 0 {
+		pattern, err = terf.ParseFilenamePattern(expr, c.String("filename-types"), c.Bool("pattern-source-path"))
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if len(c.String("filename-types")) > 0 || c.Bool("pattern-source-path") {
+		return nil, nil, errors.New("filename-types and pattern-source-path require filename-pattern")
 	}
 
-	in.Seek(0, 0)
-	r := csv.NewReader(in)
+	var exif []string
+	if len(c.String("exif")) > 0 {
+		exif, err = terf.ParseEXIFFields(c.String("exif"))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-	// Parse header info
-	header, err := r.Read()
+	tile, err := buildTile(c)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// Sanity check
-	if header[0] != "image_path" {
-		return errors.New("Invalid header")
+	augment, err := buildAugment(c)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	shard := &Shard{
-		ID:       1,
-		Total:    total,
-		Name:     name,
-		BaseDir:  outdir,
-		Compress: compress,
-		Records:  make([][]string, 0),
+	imageOpts := &dataset.ImageOptions{
+		Profile:       profile,
+		Convert:       convert,
+		JPEGQuality:   quality,
+		SkipReencode:  c.Bool("jpeg-skip-reencode"),
+		Page:          c.Int("tiff-page"),
+		SourceInfo:    c.Bool("source-info"),
+		StripMetadata: c.Bool("strip-metadata"),
+		EXIF:          exif,
+		Pattern:       pattern,
+		Validate:      c.Bool("validate"),
+		Hashes:        hashes,
+		Transforms:    transforms,
+		Tile:          tile,
+		Augment:       augment,
+		PixelEncoding: pixels,
 	}
 
-	g, ctx := errgroup.WithContext(context.TODO())
-	shards := make(chan *Shard, total)
+	return opts, imageOpts, nil
+}
 
-	g.Go(func() error {
-		defer close(shards)
+// buildAugment returns the augmentation options for the build command line
+// options or nil if images are not augmented
+func buildAugment(c *cli.Context) (*terf.AugmentOptions, error) {
+	opts := &terf.AugmentOptions{
+		Seed:       c.Int64("augment-seed"),
+		Copies:     c.Int("augment"),
+		Multiplier: make(map[int]int),
+		FlipH:      c.Bool("augment-fliph"),
+		FlipV:      c.Bool("augment-flipv"),
+		Rotate:     c.Bool("augment-rotate"),
+		Brightness: c.Float64("augment-brightness"),
+		Contrast:   c.Float64("augment-contrast"),
+		Crop:       c.Float64("augment-crop"),
+	}
+
+	if opts.Copies < 0 {
+		return nil, errors.New("augment must be a positive number of copies")
+	}
+	if opts.Brightness < 0 || opts.Brightness > 1 {
+		return nil, errors.New("augment-brightness must be between 0 and 1")
+	}
+	if opts.Contrast < 0 || opts.Contrast > 1 {
+		return nil, errors.New("augment-contrast must be between 0 and 1")
+	}
+	if opts.Crop < 0 || opts.Crop > 1 {
+		return nil, errors.New("augment-crop must be between 0 and 1")
+	}
 
-		for {
-			row, err := r.Read()
-			if err == io.EOF {
-				break
+	if classes := c.String("augment-class"); len(classes) > 0 {
+		for _, pair := range strings.Split(classes, ",") {
+			parts := strings.Split(pair, "=")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid augment-class %s, should be label_id=copies", pair)
 			}
+
+			label, err := strconv.Atoi(strings.TrimSpace(parts[0]))
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("Invalid augment-class label id: %s", parts[0])
 			}
-
-			shard.Records = append(shard.Records, row)
-
-			if len(shard.Records)%numPerBatch == 0 {
-				select {
-				case shards <- shard:
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-				shard = shard.Next()
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid augment-class copies: %s", parts[1])
 			}
+			opts.Multiplier[label] = n
 		}
+	}
 
-		if len(shard.Records) > 0 {
-			select {
-			case shards <- shard:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+	copies := opts.Copies
+	for _, n := range opts.Multiplier {
+		copies += n
+	}
+
+	if copies == 0 {
+		if opts.Enabled() {
+			return nil, errors.New("Augmentation options require augment or augment-class")
 		}
+		return nil, nil
+	}
 
-		return nil
-	})
+	if !opts.Enabled() {
+		return nil, errors.New("augment requires at least one of augment-fliph, augment-flipv, augment-rotate, augment-brightness, augment-contrast or augment-crop")
+	}
 
-	for i := 0; i < threads; i++ {
-		g.Go(func() error {
-			for shard := range shards {
+	return opts, nil
+}
 
-				err := process(shard, jpeg)
-				if err != nil {
-					return err
-				}
+// buildTile returns the tile options for the build command line options or
+// nil if images are not tiled
+func buildTile(c *cli.Context) (*terf.TileOptions, error) {
+	if len(c.String("tile")) == 0 {
+		if len(c.String("tile-stride")) > 0 || len(c.String("tile-edge")) > 0 {
+			return nil, errors.New("tile-stride and tile-edge require tile")
+		}
+		return nil, nil
+	}
 
-				select {
-				default:
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
+	w, h, err := parseSize(c.String("tile"))
+	if err != nil {
+		return nil, err
+	}
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("Invalid tile size %s, should be WxH", c.String("tile"))
+	}
 
-			return nil
-		})
+	opts := &terf.TileOptions{Width: w, Height: h}
+
+	if stride := c.String("tile-stride"); len(stride) > 0 {
+		opts.StrideX, opts.StrideY, err = parseSize(stride)
+		if err != nil {
+			return nil, err
+		}
+		if opts.StrideX == 0 || opts.StrideY == 0 {
+			return nil, fmt.Errorf("Invalid tile stride %s, should be WxH", stride)
+		}
 	}
 
-	if err := g.Wait(); err != nil {
-		return err
+	opts.Edge, err = terf.ParseEdgePolicy(c.String("tile-edge"))
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	opts.Fill, err = terf.ParseColor(c.String("pad-color"))
+	if err != nil {
+		return nil, err
+	}
+
+	return opts, nil
 }
 
-func process(shard *Shard, jpeg bool) error {
-	outfile := fmt.Sprintf("%s-%.5d-of-%.5d", shard.Name, shard.ID, shard.Total)
+// buildTransforms returns the image transforms for the build command line
+// options
+func buildTransforms(c *cli.Context) ([]terf.Transform, error) {
+	transforms := make([]terf.Transform, 0)
 
-	log.WithFields(log.Fields{
-		"file":    outfile,
-		"records": len(shard.Records),
-		"zlib":    shard.Compress,
-	}).Info("Processing shard")
+	if size := c.String("center-crop"); len(size) > 0 {
+		w, h, err := parseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, terf.CenterCrop(w, h))
+	}
 
-	out, err := os.Create(filepath.Join(shard.BaseDir, outfile))
-	if err != nil {
-		return err
+	if f := c.Float64("center-crop-fraction"); f != 0 {
+		if f < 0 || f > 1 {
+			return nil, errors.New("center-crop-fraction must be between 0 and 1")
+		}
+		transforms = append(transforms, terf.CenterCropFraction(f))
 	}
-	defer out.Close()
 
-	var w *terf.Writer
+	if c.Bool("pad-square") {
+		fill, err := terf.ParseColor(c.String("pad-color"))
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, terf.PadSquare(fill))
+	}
 
-	if shard.Compress {
-		zout := zlib.NewWriter(out)
-		defer zout.Close()
+	filter, err := terf.ParseFilter(c.String("filter"))
+	if err != nil {
+		return nil, err
+	}
 
-		w = terf.NewWriter(zout)
-	} else {
-		w = terf.NewWriter(out)
+	resize := terf.ResizeOptions{
+		MaxDim:    c.Int("resize-max"),
+		ShortSide: c.Int("resize-short"),
+		Filter:    filter,
 	}
 
-	for _, row := range shard.Records {
-		img := &terf.Image{}
-		err := img.UnmarshalCSV(row)
+	if size := c.String("resize"); len(size) > 0 {
+		resize.Width, resize.Height, err = parseSize(size)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		if jpeg {
-			err := img.ToJPEG()
-			if err != nil {
-				return err
-			}
+	set := 0
+	for _, v := range []bool{resize.MaxDim > 0, resize.ShortSide > 0, resize.Width > 0 || resize.Height > 0} {
+		if v {
+			set++
 		}
+	}
+	if set > 1 {
+		return nil, errors.New("Only one of resize, resize-max or resize-short can be set")
+	}
+	if set == 1 {
+		transforms = append(transforms, terf.Resize(resize))
+	}
 
-		ex, err := img.MarshalExample()
-		if err != nil {
-			return err
+	return transforms, nil
+}
+
+// parseSize parses a size in the form WxH. Either W or H can be omitted to
+// preserve the aspect ratio
+func parseSize(size string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid size %s, should be WxH", size)
+	}
+
+	dims := make([]int, 2)
+	for i, p := range parts {
+		if len(p) == 0 {
+			continue
 		}
 
-		err = w.Write(ex)
-		if err != nil {
-			return err
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("Invalid size %s, should be WxH", size)
 		}
+		dims[i] = n
 	}
 
-	w.Flush()
-	if err := w.Error(); err != nil {
-		return err
+	if dims[0] == 0 && dims[1] == 0 {
+		return 0, 0, fmt.Errorf("Invalid size %s, should be WxH", size)
 	}
 
-	return nil
+	return dims[0], dims[1], nil
 }
@@ -0,0 +1,80 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/terf/dataset"
+)
+
+// Dedupe finds duplicate images in the TFRecords file(s) at inputPath and
+// writes a CSV report to output (stdout if empty). If buildOpts is not nil the
+// dataset is rewritten without the dropped duplicates.
+func Dedupe(ctx context.Context, inputPath, output string, opts *dataset.DedupeOptions, buildOpts *dataset.BuildOptions) error {
+	res, err := dataset.Dedupe(ctx, inputPath, opts)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(output) > 0 {
+		out, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+
+	err = res.WriteCSV(w)
+	if err != nil {
+		return err
+	}
+
+	dropped := 0
+	for _, g := range res.Groups {
+		for _, d := range g.Images {
+			if d.Drop {
+				dropped++
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"groups":  len(res.Groups),
+		"dropped": dropped,
+	}).Info("Dedupe complete")
+
+	if buildOpts != nil {
+		build, err := res.Rewrite(ctx, inputPath, opts.Compress, buildOpts)
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"shards":  len(build.Shards),
+			"records": build.Total,
+		}).Info("Rewrite complete")
+	}
+
+	return fileErrors(res.Errors)
+}
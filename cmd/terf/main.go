@@ -18,14 +18,81 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
 	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/terf"
+	"github.com/ubccr/terf/dataset"
 	"github.com/urfave/cli"
 )
 
 var (
 	TerfVersion = "dev"
+
+	profileFlag = &cli.StringFlag{
+		Name:  "profile,p",
+		Usage: fmt.Sprintf("Feature key profile (%s) or custom field=key mappings", strings.Join(terf.ProfileNames(), ", ")),
+	}
+
+	keepGoingFlag = &cli.BoolFlag{Name: "keep-going,k", Usage: "Continue with the remaining files if a file fails"}
 )
 
+// logProgress logs dataset progress events
+func logProgress(ev dataset.Event) {
+	switch ev.Type {
+	case dataset.ShardStarted:
+		log.WithFields(log.Fields{
+			"file":    filepath.Base(ev.Path),
+			"records": ev.Records,
+			"zlib":    ev.Compress,
+		}).Info("Processing shard")
+	case dataset.FileStarted:
+		log.WithFields(log.Fields{
+			"path": ev.Path,
+			"zlib": ev.Compress,
+		}).Info("Processing file")
+	case dataset.FileFailed:
+		log.WithFields(log.Fields{
+			"path":  ev.Path,
+			"error": ev.Err,
+		}).Error("Failed to process file")
+	case dataset.RecordFailed:
+		log.WithFields(log.Fields{
+			"record": ev.Record,
+			"error":  ev.Err,
+		}).Warn("Rejected record")
+	}
+}
+
+// fileErrors returns an error summarizing any failed files
+func fileErrors(errs []*dataset.FileError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Failed to process %d file(s)", len(errs))
+}
+
+// signalContext returns a context that is cancelled on interrupt
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		log.Warn("Interrupted, stopping")
+		cancel()
+	}()
+
+	return ctx
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "terf"
@@ -55,10 +122,51 @@ func main() {
 				&cli.IntFlag{Name: "size,n", Usage: "Number of examples per batch"},
 				&cli.IntFlag{Name: "threads,t", Usage: "Num threads"},
 				&cli.BoolFlag{Name: "compress,z", Usage: "Use zlib compression"},
-				&cli.BoolFlag{Name: "jpeg,j", Usage: "Convert images to JPEG in RGB colorspace"},
+				&cli.BoolFlag{Name: "jpeg,j", Usage: "Convert images to JPEG in RGB colorspace. Same as --convert rgb"},
+				&cli.StringFlag{Name: "convert", Usage: "Convert images to rgb (JPEG), gray (JPEG), png (lossless), raw (decoded pixels) or none (keep original)"},
+				&cli.StringFlag{Name: "pixel-encoding", Usage: "Feature type for raw pixels: bytes, int64 or float (default bytes)"},
+				&cli.IntFlag{Name: "jpeg-quality", Usage: "JPEG quality (1-100) when encoding JPEG images, 0 uses the default of 75"},
+				&cli.BoolFlag{Name: "jpeg-skip-reencode", Usage: "Do not re-encode images that are already JPEG in the target colorspace"},
+				&cli.IntFlag{Name: "tiff-page", Usage: "Page (starting at 0) of multi-page TIFF images"},
+				&cli.BoolFlag{Name: "source-info", Usage: "Store the source path and modification time of image files"},
+				&cli.BoolFlag{Name: "strip-metadata", Usage: "Remove EXIF, XMP, ICC profiles and comments from images. TIFF and GIF images are re-encoded as PNG"},
+				&cli.StringFlag{Name: "filename-pattern", Usage: "Regular expression with named groups matched against image file names. Each group is stored as a feature"},
+				&cli.StringFlag{Name: "filename-types", Usage: "Comma separated group=type pairs (int, float, string) for filename-pattern groups (default string)"},
+				&cli.BoolFlag{Name: "pattern-source-path", Usage: "Match filename-pattern against the absolute source path instead of the file name"},
+				&cli.StringFlag{Name: "exif", Usage: fmt.Sprintf("Comma separated EXIF fields to store as features (all, %s)", strings.Join(terf.EXIFFieldNames(), ", "))},
+				&cli.StringFlag{Name: "hash", Usage: fmt.Sprintf("Comma separated perceptual hashes to store (%s)", strings.Join(terf.HashNames(), ", "))},
+				&cli.BoolFlag{Name: "validate", Usage: "Fully decode images and reject truncated, empty or mislabeled images"},
+				&cli.BoolFlag{Name: "keep-going,k", Usage: "Leave out images that fail instead of stopping the build"},
+				&cli.StringFlag{Name: "rejects", Usage: "Path to CSV file of rejected rows with the reason"},
+				&cli.StringFlag{Name: "center-crop", Usage: "Crop WxH pixels from the center of images. Omit W or H to only crop one dimension"},
+				&cli.Float64Flag{Name: "center-crop-fraction", Usage: "Crop the central fraction (0-1] of the width and height of images"},
+				&cli.BoolFlag{Name: "pad-square", Usage: "Pad images to a square"},
+				&cli.StringFlag{Name: "pad-color", Value: "#000000", Usage: "Fill color for pad-square and padded tiles"},
+				&cli.IntFlag{Name: "resize-max", Usage: "Resize images so the longest side is at most N pixels"},
+				&cli.IntFlag{Name: "resize-short", Usage: "Resize images so the shortest side is N pixels"},
+				&cli.StringFlag{Name: "resize", Usage: "Resize images to WxH pixels. Omit W or H to preserve the aspect ratio"},
+				&cli.StringFlag{Name: "filter", Usage: "Resampling filter for resizing (nearest, approxbilinear, bilinear, catmullrom)"},
+				&cli.StringFlag{Name: "tile", Usage: "Split images into WxH pixel tiles after the transforms"},
+				&cli.StringFlag{Name: "tile-stride", Usage: "Distance WxH in pixels between tiles. Smaller than tile for overlapping tiles (default tile size)"},
+				&cli.StringFlag{Name: "tile-edge", Usage: "Policy for partial edge tiles: drop, keep, pad or shift (default drop)"},
+				&cli.IntFlag{Name: "augment", Usage: "Number of random augmented copies of each image"},
+				&cli.StringFlag{Name: "augment-class", Usage: "Comma separated label_id=copies pairs overriding augment for rare classes"},
+				&cli.Int64Flag{Name: "augment-seed", Usage: "Seed for the random augmentations"},
+				&cli.BoolFlag{Name: "augment-fliph", Usage: "Randomly flip augmented images horizontally"},
+				&cli.BoolFlag{Name: "augment-flipv", Usage: "Randomly flip augmented images vertically"},
+				&cli.BoolFlag{Name: "augment-rotate", Usage: "Randomly rotate augmented images by multiples of 90 degrees"},
+				&cli.Float64Flag{Name: "augment-brightness", Usage: "Maximum brightness shift (0-1) of augmented images"},
+				&cli.Float64Flag{Name: "augment-contrast", Usage: "Maximum relative contrast change (0-1) of augmented images"},
+				&cli.Float64Flag{Name: "augment-crop", Usage: "Minimum fraction (0-1] of the width and height kept by random crops"},
+				profileFlag,
 			},
 			Action: func(c *cli.Context) error {
-				err := Build(c.String("input"), c.String("outdir"), c.String("name"), c.Int("size"), c.Int("threads"), c.Bool("compress"), c.Bool("jpeg"))
+				opts, imageOpts, err := buildOptions(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				err = Build(signalContext(), c.String("input"), c.String("rejects"), opts, imageOpts)
 				if err != nil {
 					log.Fatal(err)
 					return cli.NewExitError(err, 1)
@@ -75,9 +183,22 @@ func main() {
 				&cli.StringFlag{Name: "outdir,o", Usage: "Path to outdir"},
 				&cli.IntFlag{Name: "threads,t", Usage: "Num threads"},
 				&cli.BoolFlag{Name: "compress,z", Usage: "Use zlib compression"},
+				keepGoingFlag,
+				profileFlag,
+				&cli.StringFlag{Name: "multi-label", Usage: "Directory of multi-label images: first (first label), join (labels joined with +) or copy (each label)"},
 			},
 			Action: func(c *cli.Context) error {
-				err := Extract(c.String("input"), c.String("outdir"), c.Int("threads"), c.Bool("compress"))
+				profile, err := terf.ParseProfile(c.String("profile"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				policy, err := dataset.ParseMultiLabelPolicy(c.String("multi-label"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				err = Extract(signalContext(), c.String("input"), c.String("outdir"), c.Int("threads"), c.Bool("compress"), c.Bool("keep-going"), profile, policy)
 				if err != nil {
 					log.Fatal(err)
 					return cli.NewExitError(err, 1)
@@ -93,9 +214,127 @@ func main() {
 				&cli.StringFlag{Name: "input, i", Usage: "Input file"},
 				&cli.IntFlag{Name: "threads,t", Usage: "Num threads"},
 				&cli.BoolFlag{Name: "compress,z", Usage: "Use zlib compression"},
+				&cli.BoolFlag{Name: "pixels", Usage: "Decode images and compute per-channel pixel mean, standard deviation and histograms"},
+				&cli.IntFlag{Name: "pixel-channels", Value: 3, Usage: "Number of channels (1 or 3) for pixel statistics"},
+				&cli.StringFlag{Name: "histogram", Usage: "Path to CSV file of pixel histograms. Implies --pixels"},
+				keepGoingFlag,
+				profileFlag,
+			},
+			Action: func(c *cli.Context) error {
+				profile, err := terf.ParseProfile(c.String("profile"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				channels := c.Int("pixel-channels")
+				if channels != 1 && channels != 3 {
+					return cli.NewExitError("pixel-channels must be 1 or 3", 1)
+				}
+
+				opts := &dataset.SummaryOptions{
+					Threads:       c.Int("threads"),
+					Compress:      c.Bool("compress"),
+					KeepGoing:     c.Bool("keep-going"),
+					Profile:       profile,
+					Pixels:        c.Bool("pixels") || len(c.String("histogram")) > 0,
+					PixelChannels: channels,
+				}
+
+				err = Summary(signalContext(), c.String("input"), c.String("histogram"), opts)
+				if err != nil {
+					log.Fatal(err)
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "dedupe",
+			Usage: "Find exact and near duplicate images in TFRecords file(s)",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "input,i", Usage: "Path to input"},
+				&cli.StringFlag{Name: "other", Usage: "Path to a second dataset. Only duplicates between the datasets are reported"},
+				&cli.StringFlag{Name: "output,o", Usage: "Path to CSV report (default stdout)"},
+				&cli.StringFlag{Name: "hash", Value: "dhash", Usage: fmt.Sprintf("Perceptual hash (%s)", strings.Join(terf.HashNames(), ", "))},
+				&cli.IntFlag{Name: "distance,d", Value: 4, Usage: "Maximum Hamming distance of near duplicates. Negative for exact duplicates only"},
+				&cli.StringFlag{Name: "outdir", Usage: "Rewrite the input dataset without the dropped duplicates to outdir"},
+				&cli.StringFlag{Name: "name,l", Usage: "Name of rewritten shards"},
+				&cli.IntFlag{Name: "size,n", Usage: "Number of examples per rewritten shard"},
+				&cli.IntFlag{Name: "threads,t", Usage: "Num threads"},
+				&cli.BoolFlag{Name: "compress,z", Usage: "Use zlib compression"},
+				keepGoingFlag,
+				profileFlag,
+			},
+			Action: func(c *cli.Context) error {
+				profile, err := terf.ParseProfile(c.String("profile"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				opts := &dataset.DedupeOptions{
+					Other:     c.String("other"),
+					Hash:      c.String("hash"),
+					Distance:  c.Int("distance"),
+					Threads:   c.Int("threads"),
+					Compress:  c.Bool("compress"),
+					Profile:   profile,
+					KeepGoing: c.Bool("keep-going"),
+					Progress:  logProgress,
+				}
+
+				var buildOpts *dataset.BuildOptions
+				if len(c.String("outdir")) > 0 {
+					buildOpts = &dataset.BuildOptions{
+						OutDir:   c.String("outdir"),
+						Name:     c.String("name"),
+						Size:     c.Int("size"),
+						Threads:  c.Int("threads"),
+						Compress: c.Bool("compress"),
+						Progress: logProgress,
+					}
+				}
+
+				err = Dedupe(signalContext(), c.String("input"), c.String("output"), opts, buildOpts)
+				if err != nil {
+					log.Fatal(err)
+					return cli.NewExitError(err, 1)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "quality",
+			Usage: "Measure image quality and flag outliers per source in TFRecords file(s)",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "input,i", Usage: "Path to input"},
+				&cli.StringFlag{Name: "output,o", Usage: "Path to CSV report of flagged images (default stdout)"},
+				&cli.BoolFlag{Name: "all", Usage: "Report all images instead of only flagged images"},
+				&cli.Float64Flag{Name: "threshold", Value: 3.5, Usage: "Modified z-score above which a metric is an outlier"},
+				&cli.IntFlag{Name: "min-images", Value: 5, Usage: "Minimum number of images of a source to flag outliers"},
+				&cli.IntFlag{Name: "threads,t", Usage: "Num threads"},
+				&cli.BoolFlag{Name: "compress,z", Usage: "Use zlib compression"},
+				keepGoingFlag,
+				profileFlag,
 			},
 			Action: func(c *cli.Context) error {
-				err := Summary(c.String("input"), c.Int("threads"), c.Bool("compress"))
+				profile, err := terf.ParseProfile(c.String("profile"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+
+				opts := &dataset.QualityOptions{
+					Threshold: c.Float64("threshold"),
+					MinImages: c.Int("min-images"),
+					Threads:   c.Int("threads"),
+					Compress:  c.Bool("compress"),
+					Profile:   profile,
+					KeepGoing: c.Bool("keep-going"),
+					Progress:  logProgress,
+				}
+
+				err = Quality(signalContext(), c.String("input"), c.String("output"), c.Bool("all"), opts)
 				if err != nil {
 					log.Fatal(err)
 					return cli.NewExitError(err, 1)
@@ -0,0 +1,59 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ubccr/terf/dataset"
+)
+
+// Quality measures the quality of the images in the TFRecords file(s) at
+// inputPath and writes a CSV report of the flagged images, or all images if
+// all is set, to output (stdout if empty)
+func Quality(ctx context.Context, inputPath, output string, all bool, opts *dataset.QualityOptions) error {
+	res, err := dataset.Quality(ctx, inputPath, opts)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(output) > 0 {
+		out, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+
+	err = res.WriteCSV(w, all)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"images":  len(res.Images),
+		"flagged": len(res.Flagged()),
+	}).Info("Quality check complete")
+
+	return fileErrors(res.Errors)
+}
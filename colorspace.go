@@ -0,0 +1,117 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"image/color"
+)
+
+const (
+	// PNG color types from the IHDR chunk
+	pngGray      = 0
+	pngRGB       = 2
+	pngPalette   = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ColorInfo describes the colorspace of encoded image data
+type ColorInfo struct {
+	// Colorspace name: Gray, GrayAlpha, RGB, RGBA, CMYK or Unknown
+	Colorspace string
+
+	// Number of channels produced when decoding the image
+	Channels int
+
+	// Bits per channel
+	BitDepth int
+}
+
+// DetectColor returns the colorspace, channel count and bit depth of the
+// encoded image data raw with the given color model as returned by
+// image.DecodeConfig. PNG images are inspected directly as the Go decoder
+// reports gray with alpha images as NRGBA.
+func DetectColor(raw []byte, model color.Model) ColorInfo {
+	if info, ok := pngColor(raw); ok {
+		return info
+	}
+
+	switch model {
+	case color.YCbCrModel, color.RGBAModel:
+		return ColorInfo{"RGB", 3, 8}
+	case color.NYCbCrAModel, color.NRGBAModel:
+		return ColorInfo{"RGBA", 4, 8}
+	case color.RGBA64Model:
+		return ColorInfo{"RGB", 3, 16}
+	case color.NRGBA64Model:
+		return ColorInfo{"RGBA", 4, 16}
+	case color.CMYKModel:
+		return ColorInfo{"CMYK", 4, 8}
+	case color.GrayModel:
+		return ColorInfo{"Gray", 1, 8}
+	case color.Gray16Model:
+		return ColorInfo{"Gray", 1, 16}
+	}
+
+	if p, ok := model.(color.Palette); ok {
+		return paletteColor(p)
+	}
+
+	return ColorInfo{"Unknown", 0, 0}
+}
+
+// paletteColor returns the colorspace of a paletted image. Palettes are
+// expanded to RGB, or RGBA if any entry is transparent.
+func paletteColor(p color.Palette) ColorInfo {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return ColorInfo{"RGBA", 4, 8}
+		}
+	}
+
+	return ColorInfo{"RGB", 3, 8}
+}
+
+// pngColor returns the colorspace from the IHDR chunk of PNG image data
+func pngColor(raw []byte) (ColorInfo, bool) {
+	// signature (8) + length (4) + type (4) + width (4) + height (4) + depth + color type
+	if len(raw) < 26 || !bytes.HasPrefix(raw, pngSignature) || string(raw[12:16]) != "IHDR" {
+		return ColorInfo{}, false
+	}
+
+	depth := int(raw[24])
+
+	switch raw[25] {
+	case pngGray:
+		return ColorInfo{"Gray", 1, depth}, true
+	case pngRGB:
+		return ColorInfo{"RGB", 3, depth}, true
+	case pngGrayAlpha:
+		return ColorInfo{"GrayAlpha", 2, depth}, true
+	case pngRGBA:
+		return ColorInfo{"RGBA", 4, depth}, true
+	case pngPalette:
+		// Palette transparency requires the PLTE and tRNS chunks
+		return ColorInfo{}, false
+	}
+
+	return ColorInfo{}, false
+}
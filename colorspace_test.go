@@ -0,0 +1,88 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDetectColor(t *testing.T) {
+	r := image.Rect(0, 0, 4, 4)
+
+	translucent := image.NewNRGBA(r)
+	translucent.Set(0, 0, color.NRGBA{R: 10, A: 10})
+
+	opaque := image.NewRGBA(r)
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+
+	paletted := image.NewPaletted(r, color.Palette{color.Black, color.Transparent})
+
+	tests := []struct {
+		name   string
+		im     image.Image
+		format string
+		info   ColorInfo
+	}{
+		{"png gray", image.NewGray(r), "png", ColorInfo{"Gray", 1, 8}},
+		{"png gray16", image.NewGray16(r), "png", ColorInfo{"Gray", 1, 16}},
+		{"png rgb", opaque, "png", ColorInfo{"RGB", 3, 8}},
+		{"png rgba", translucent, "png", ColorInfo{"RGBA", 4, 8}},
+		{"png paletted", paletted, "png", ColorInfo{"RGBA", 4, 8}},
+		{"gif paletted", paletted, "gif", ColorInfo{"RGB", 3, 8}},
+		{"jpeg gray", image.NewGray(r), "jpeg", ColorInfo{"Gray", 1, 8}},
+		{"jpeg rgb", opaque, "jpeg", ColorInfo{"RGB", 3, 8}},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		var err error
+		switch test.format {
+		case "png":
+			err = png.Encode(buf, test.im)
+		case "gif":
+			err = gif.Encode(buf, test.im, nil)
+		case "jpeg":
+			err = jpeg.Encode(buf, test.im, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im := &Image{}
+		if err := im.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		info := ColorInfo{im.Colorspace, im.Channels, im.BitDepth}
+		if info != test.info {
+			t.Errorf("%s: incorrect color info: got %+v should be %+v", test.name, info, test.info)
+		}
+	}
+
+	if info := DetectColor(nil, color.CMYKModel); info.Colorspace != "CMYK" || info.Channels != 4 {
+		t.Errorf("Incorrect CMYK color info: got %+v", info)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+	"golang.org/x/sync/errgroup"
+)
+
+// BuildOptions are the options for building a sharded dataset
+type BuildOptions struct {
+	// Output directory. Defaults to the current working directory
+	OutDir string
+
+	// Base name of the shard files. Defaults to train
+	Name string
+
+	// Number of input records per shard. Defaults to 1024. Records that
+	// implement terf.ExamplesMarshaler write all their Examples to the shard
+	// of the record
+	Size int
+
+	// Number of shards to build concurrently. Defaults to the number of CPUs
+	Threads int
+
+	// Use zlib compression
+	Compress bool
+
+	// Leave out records that fail to convert instead of stopping the build.
+	// Failed records are reported in BuildResult.Rejects and shards may hold
+	// fewer than Size records
+	KeepGoing bool
+
+	// Called with ShardStarted, ShardDone and RecordFailed progress events
+	Progress ProgressFunc
+}
+
+// ShardInfo describes a shard file written by Build
+type ShardInfo struct {
+	// Path of the shard file
+	Path string
+
+	// Shard number starting at 1
+	ID int
+
+	// Number of Examples written to the shard
+	Records int
+}
+
+// BuildResult is the result of Build
+type BuildResult struct {
+	// Shards written ordered by ID
+	Shards []*ShardInfo
+
+	// Total number of Examples written
+	Total int
+
+	// Records left out when KeepGoing is set ordered by index
+	Rejects []*RecordError
+}
+
+type shard struct {
+	baseDir  string
+	name     string
+	id       int
+	total    int
+	start    int
+	compress bool
+	records  []terf.ExampleMarshaler
+}
+
+func (s *shard) next() *shard {
+	return &shard{
+		baseDir:  s.baseDir,
+		name:     s.name,
+		total:    s.total,
+		id:       s.id + 1,
+		start:    s.start + len(s.records),
+		compress: s.compress,
+		records:  make([]terf.ExampleMarshaler, 0),
+	}
+}
+
+// shardResult is the result of writing a shard
+type shardResult struct {
+	info    *ShardInfo
+	rejects []*RecordError
+}
+
+// Build writes the records from src into sharded TFRecords files. Records are
+// assigned to shards sequentially and each shard is written in input order
+// so identical inputs produce byte-identical shards regardless of the number
+// of threads. Cancelling ctx stops the build and returns the context error.
+func Build(ctx context.Context, src Source, opts *BuildOptions) (*BuildResult, error) {
+	outdir := opts.OutDir
+	if len(outdir) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		outdir = cwd
+	}
+
+	err := os.MkdirAll(outdir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	threads := opts.Threads
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	name := opts.Name
+	if len(name) == 0 {
+		name = "train"
+	}
+
+	numPerBatch := opts.Size
+	if numPerBatch == 0 {
+		numPerBatch = 1024
+	}
+
+	lener, ok := src.(Lener)
+	if !ok {
+		buffered, err := readAll(src)
+		if err != nil {
+			return nil, err
+		}
+		src, lener = buffered, buffered
+	}
+
+	total, err := lener.Len()
+	if err != nil {
+		return nil, err
+	}
+
+	if numPerBatch > total {
+		total = 1
+	} else {
+		total = int(math.Ceil(float64(total) / float64(numPerBatch)))
+	}
+
+	sh := &shard{
+		id:       1,
+		total:    total,
+		name:     name,
+		baseDir:  outdir,
+		compress: opts.Compress,
+		records:  make([]terf.ExampleMarshaler, 0),
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	shards := make(chan *shard, total)
+
+	// Results are collected under a lock rather than sent on a channel sized
+	// to total, which is only an estimate for CSV sources and must never block
+	// the workers
+	var mu sync.Mutex
+	results := make([]*shardResult, 0, total)
+
+	g.Go(func() error {
+		defer close(shards)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rec, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			sh.records = append(sh.records, rec)
+
+			if len(sh.records)%numPerBatch == 0 {
+				select {
+				case shards <- sh:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				sh = sh.next()
+			}
+		}
+
+		if len(sh.records) > 0 {
+			select {
+			case shards <- sh:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	for i := 0; i < threads; i++ {
+		g.Go(func() error {
+			for sh := range shards {
+
+				res, err := writeShard(ctx, sh, opts.KeepGoing, opts.Progress)
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	res := &BuildResult{
+		Shards:  make([]*ShardInfo, 0, len(results)),
+		Rejects: make([]*RecordError, 0),
+	}
+	for _, r := range results {
+		res.Shards = append(res.Shards, r.info)
+		res.Total += r.info.Records
+		res.Rejects = append(res.Rejects, r.rejects...)
+	}
+	sort.Slice(res.Shards, func(i, j int) bool { return res.Shards[i].ID < res.Shards[j].ID })
+	sort.Slice(res.Rejects, func(i, j int) bool { return res.Rejects[i].Index < res.Rejects[j].Index })
+
+	return res, nil
+}
+
+// writeShard writes the records of sh to the shard file. If keepGoing is set
+// records that fail to convert are left out and returned as rejects.
+func writeShard(ctx context.Context, sh *shard, keepGoing bool, progress ProgressFunc) (*shardResult, error) {
+	outfile := filepath.Join(sh.baseDir, fmt.Sprintf("%s-%.5d-of-%.5d", sh.name, sh.id, sh.total))
+
+	notify(progress, Event{Type: ShardStarted, Path: outfile, Records: len(sh.records), Compress: sh.compress})
+
+	out, err := os.Create(outfile)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	var w *terf.Writer
+	var zout *zlib.Writer
+
+	if sh.compress {
+		zout = zlib.NewWriter(out)
+
+		w = terf.NewWriter(zout)
+	} else {
+		w = terf.NewWriter(out)
+	}
+
+	written := 0
+	rejects := make([]*RecordError, 0)
+	for n, rec := range sh.records {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		examples, err := marshalExamples(rec)
+		if err != nil {
+			if !keepGoing {
+				return nil, err
+			}
+
+			rejects = append(rejects, &RecordError{Index: sh.start + n, Record: rec, Err: err})
+			notify(progress, Event{Type: RecordFailed, Path: outfile, Record: sh.start + n, Compress: sh.compress, Err: err})
+			continue
+		}
+
+		for _, ex := range examples {
+			err = w.Write(ex)
+			if err != nil {
+				return nil, err
+			}
+		}
+		written += len(examples)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	if zout != nil {
+		if err := zout.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	info := &ShardInfo{Path: outfile, ID: sh.id, Records: written}
+	notify(progress, Event{Type: ShardDone, Path: outfile, Records: info.Records, Compress: sh.compress})
+
+	return &shardResult{info: info, rejects: rejects}, nil
+}
+
+// marshalExamples returns the Examples of rec using MarshalExamples if rec
+// implements terf.ExamplesMarshaler
+func marshalExamples(rec terf.ExampleMarshaler) ([]*protobuf.Example, error) {
+	if m, ok := rec.(terf.ExamplesMarshaler); ok {
+		return m.MarshalExamples()
+	}
+
+	ex, err := rec.MarshalExample()
+	if err != nil {
+		return nil, err
+	}
+
+	return []*protobuf.Example{ex}, nil
+}
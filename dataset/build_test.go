@@ -0,0 +1,155 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+type textRecord struct {
+	id   int
+	text string
+}
+
+func (r *textRecord) MarshalExample() (*protobuf.Example, error) {
+	return &protobuf.Example{
+		Features: &protobuf.Features{
+			Feature: map[string]*protobuf.Feature{
+				"id":   terf.Int64Feature(int64(r.id)),
+				"text": terf.BytesFeature([]byte(r.text)),
+			},
+		},
+	}, nil
+}
+
+func readShard(t *testing.T, path string) []int {
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	ids := make([]int, 0)
+	r := terf.NewReader(in)
+	for {
+		ex, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, terf.ExampleFeatureInt64(ex, "id"))
+	}
+
+	return ids
+}
+
+func TestBuildCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines := []string{"id,text"}
+	for i := 1; i <= 7; i++ {
+		lines = append(lines, strconv.Itoa(i)+",row"+strconv.Itoa(i))
+	}
+
+	src, err := NewCSVSource(strings.NewReader(strings.Join(lines, "\n")+"\n"), func(header, row []string) (terf.ExampleMarshaler, error) {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, err
+		}
+		return &textRecord{id: id, text: row[1]}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Build(context.Background(), src, &BuildOptions{OutDir: dir, Name: "text", Size: 3, Threads: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Total != 7 || len(res.Shards) != 3 {
+		t.Errorf("Incorrect result: got %d records in %d shards should be 7 in 3", res.Total, len(res.Shards))
+	}
+
+	expected := map[string]string{
+		"text-00001-of-00003": "1,2,3",
+		"text-00002-of-00003": "4,5,6",
+		"text-00003-of-00003": "7",
+	}
+
+	for name, ids := range expected {
+		got := make([]string, 0)
+		for _, id := range readShard(t, filepath.Join(dir, name)) {
+			got = append(got, strconv.Itoa(id))
+		}
+
+		if strings.Join(got, ",") != ids {
+			t.Errorf("Incorrect records in %s: got %v should be %s", name, got, ids)
+		}
+	}
+}
+
+func TestBuildNoTrailingNewline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Without a trailing newline the last row is not counted so Build writes
+	// one more shard than estimated
+	src, err := NewCSVSource(strings.NewReader("id,text\n1,a\n2,b\n3,c"), func(header, row []string) (terf.ExampleMarshaler, error) {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, err
+		}
+		return &textRecord{id: id, text: row[1]}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := Build(ctx, src, &BuildOptions{OutDir: dir, Name: "text", Size: 1, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Total != 3 || len(res.Shards) != 3 {
+		t.Errorf("Incorrect result: got %d records in %d shards should be 3 in 3", res.Total, len(res.Shards))
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dataset implements building, extracting and summarizing sharded
+// TFRecords datasets
+package dataset
+
+import (
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+	"golang.org/x/sync/errgroup"
+)
+
+// EventType is the type of a progress Event
+type EventType int
+
+const (
+	// ShardStarted is sent when a shard starts being written
+	ShardStarted EventType = iota
+
+	// ShardDone is sent when a shard has been written
+	ShardDone
+
+	// FileStarted is sent when an input file starts being processed
+	FileStarted
+
+	// FileDone is sent when an input file has been processed
+	FileDone
+
+	// FileFailed is sent when an input file could not be processed
+	FileFailed
+
+	// RecordFailed is sent when a record is left out of a shard because it
+	// could not be converted
+	RecordFailed
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case ShardStarted:
+		return "ShardStarted"
+	case ShardDone:
+		return "ShardDone"
+	case FileStarted:
+		return "FileStarted"
+	case FileDone:
+		return "FileDone"
+	case FileFailed:
+		return "FileFailed"
+	case RecordFailed:
+		return "RecordFailed"
+	}
+
+	return "Unknown"
+}
+
+// Event reports progress while processing a dataset
+type Event struct {
+	Type EventType
+
+	// Path of the shard or input file
+	Path string
+
+	// Index of the failed record starting at 0. Only set for RecordFailed
+	// events
+	Record int
+
+	// Number of records in the shard or file. Only set for done events
+	Records int
+
+	// Compressed with zlib
+	Compress bool
+
+	// Error for failed events
+	Err error
+}
+
+// ProgressFunc is called with progress events. It may be called concurrently
+// from multiple goroutines.
+type ProgressFunc func(Event)
+
+// FileError is an error that occurred while processing an input file
+type FileError struct {
+	Path string
+	Err  error
+}
+
+// Error returns the error message including the path
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// RecordError is an error that occurred while converting a record of a Source
+type RecordError struct {
+	// Index of the record in the Source starting at 0
+	Index int
+
+	// The record that failed
+	Record terf.ExampleMarshaler
+
+	Err error
+}
+
+// Error returns the error message including the record index
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.Index, e.Err)
+}
+
+// notify sends ev to progress if set
+func notify(progress ProgressFunc, ev Event) {
+	if progress != nil {
+		progress(ev)
+	}
+}
+
+// inputFiles returns the list of TFRecords files for inputPath. If inputPath
+// is a directory all files in the directory are returned
+func inputFiles(inputPath string) ([]string, error) {
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stat.IsDir() {
+		return []string{inputPath}, nil
+	}
+
+	files, err := ioutil.ReadDir(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		paths = append(paths, filepath.Join(inputPath, f.Name()))
+	}
+
+	return paths, nil
+}
+
+// processFiles calls fn for each file in paths using threads goroutines. If
+// keepGoing is true, errors returned by fn are collected and returned as
+// FileErrors, otherwise the first error cancels processing and is returned.
+func processFiles(ctx context.Context, paths []string, threads int, keepGoing bool, compress bool, progress ProgressFunc, fn func(ctx context.Context, path string) (int, error)) ([]*FileError, error) {
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	queue := make(chan string, len(paths))
+
+	g.Go(func() error {
+		defer close(queue)
+
+		for _, path := range paths {
+			select {
+			case queue <- path:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	errs := make(chan *FileError, len(paths))
+
+	for i := 0; i < threads; i++ {
+		g.Go(func() error {
+			for path := range queue {
+				notify(progress, Event{Type: FileStarted, Path: path, Compress: compress})
+
+				n, err := fn(ctx, path)
+				if err != nil {
+					notify(progress, Event{Type: FileFailed, Path: path, Compress: compress, Err: err})
+					if !keepGoing || ctx.Err() != nil {
+						return err
+					}
+
+					errs <- &FileError{Path: path, Err: err}
+					continue
+				}
+
+				notify(progress, Event{Type: FileDone, Path: path, Records: n, Compress: compress})
+			}
+
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	close(errs)
+
+	fileErrors := make([]*FileError, 0)
+	for e := range errs {
+		fileErrors = append(fileErrors, e)
+	}
+
+	return fileErrors, err
+}
+
+// readFile calls fn for each Example in the TFRecords file at path and
+// returns the number of Examples read
+func readFile(ctx context.Context, path string, compress bool, fn func(ex *protobuf.Example) error) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	var r *terf.Reader
+	if compress {
+		zin, err := zlib.NewReader(in)
+		if err != nil {
+			return 0, err
+		}
+		defer zin.Close()
+
+		r = terf.NewReader(zin)
+	} else {
+		r = terf.NewReader(in)
+	}
+
+	count := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		ex, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+
+		err = fn(ex)
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
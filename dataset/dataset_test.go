@@ -0,0 +1,353 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubccr/terf"
+)
+
+// writeImages writes n test PNG images to dir and returns the path of a CSV
+// file describing them
+func writeImages(t *testing.T, dir string, n int) string {
+	lines := []string{strings.Join(terf.CSVHeader, ",") + ",well"}
+	for i := 1; i <= n; i++ {
+		im := image.NewGray(image.Rect(0, 0, 8+i, 8))
+		for x := 0; x < im.Bounds().Dx(); x++ {
+			im.SetGray(x, x%8, color.Gray{Y: uint8(i * 20)})
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("img%d.png", i))
+		out, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(out, im); err != nil {
+			t.Fatal(err)
+		}
+		out.Close()
+
+		label := "Clear"
+		if i%2 == 0 {
+			label = "Crystals"
+		}
+		lines = append(lines, fmt.Sprintf("%s,%d,%d,%s,%d,%d,A%d", path, i, i%2, label, i, 100+i%3, i))
+	}
+
+	path := filepath.Join(dir, "images.csv")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func buildImages(t *testing.T, dir string, n int, opts *ImageOptions) string {
+	csvPath := writeImages(t, dir, n)
+
+	in, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	src, err := NewImageSource(in, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outdir := filepath.Join(dir, "train")
+	_, err = Build(context.Background(), src, &BuildOptions{OutDir: outdir, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return outdir
+}
+
+func TestSummaryExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	train := buildImages(t, dir, 5, nil)
+
+	sum, err := Summary(context.Background(), train, &SummaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Stats.Total != 5 {
+		t.Errorf("Incorrect total: got %d should be %d", sum.Stats.Total, 5)
+	}
+	if sum.Stats.LabelText["Crystals"] != 2 {
+		t.Errorf("Incorrect label count: got %d should be %d", sum.Stats.LabelText["Crystals"], 2)
+	}
+
+	dump := filepath.Join(dir, "dump")
+	res, err := Extract(context.Background(), train, &ExtractOptions{OutDir: dump})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Images != 5 {
+		t.Errorf("Incorrect number of images: got %d should be %d", res.Images, 5)
+	}
+
+	if _, err := os.Stat(filepath.Join(dump, "Crystals", "2.png")); err != nil {
+		t.Error(err)
+	}
+
+	in, err := os.Open(filepath.Join(dump, InfoFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	rows, err := csv.NewReader(in).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 6 {
+		t.Fatalf("Incorrect number of info rows: got %d should be %d", len(rows), 6)
+	}
+	if rows[0][len(rows[0])-1] != "well:string" {
+		t.Errorf("Missing extra column in info header: %v", rows[0])
+	}
+	for _, row := range rows[1:] {
+		if well := row[len(row)-1]; well != "A"+row[1] {
+			t.Errorf("Incorrect extra column for image %s: got %s should be A%s", row[1], well, row[1])
+		}
+	}
+
+	// The temporary rows file is removed
+	files, err := filepath.Glob(filepath.Join(dump, ".info-*"))
+	if err != nil || len(files) != 0 {
+		t.Errorf("Temporary info file not removed: %v", files)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	train := buildImages(t, dir, 3, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = Summary(ctx, train, &SummaryOptions{})
+	if err != context.Canceled {
+		t.Errorf("Incorrect error: got %v should be %v", err, context.Canceled)
+	}
+}
+
+func TestMultiLabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeImages(t, dir, 2)
+	lines := []string{
+		"image_path,image_id,label_id,label_text",
+		filepath.Join(dir, "img1.png") + ",1,1|2,Crystals|Precipitate",
+		filepath.Join(dir, "img2.png") + ",2,2,Precipitate",
+	}
+	csvPath := filepath.Join(dir, "multi.csv")
+	if err := ioutil.WriteFile(csvPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	src, err := NewImageSource(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	train := filepath.Join(dir, "train")
+	if _, err := Build(context.Background(), src, &BuildOptions{OutDir: train}); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := Summary(context.Background(), train, &SummaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Stats.LabelText["Precipitate"] != 2 {
+		t.Errorf("Incorrect label count: got %d should be %d", sum.Stats.LabelText["Precipitate"], 2)
+	}
+	if n := sum.Stats.CoOccurrence[[2]string{"Crystals", "Precipitate"}]; n != 1 {
+		t.Errorf("Incorrect co-occurrence count: got %d should be %d", n, 1)
+	}
+
+	dump := filepath.Join(dir, "dump")
+	if _, err := Extract(context.Background(), train, &ExtractOptions{OutDir: dump, MultiLabel: MultiLabelCopy}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"Crystals/1.png", "Precipitate/1.png", "Precipitate/2.png"} {
+		if _, err := os.Stat(filepath.Join(dump, path)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBuildRejects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvPath := writeImages(t, dir, 3)
+
+	bad := filepath.Join(dir, "img2.png")
+	raw, err := ioutil.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bad, raw[:len(raw)-20], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Row with an extra field
+	f, err := os.OpenFile(csvPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := filepath.Join(dir, "img0.png")
+	fmt.Fprintf(f, "%s,9,1,Crystals,9,101,A9,extra\n", malformed)
+	f.Close()
+
+	build := func(keepGoing bool) (*BuildResult, []string, error) {
+		in, err := os.Open(csvPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+
+		src, err := NewImageSource(in, &ImageOptions{Validate: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := Build(context.Background(), src, &BuildOptions{OutDir: filepath.Join(dir, "train"), KeepGoing: keepGoing})
+		return res, src.Header(), err
+	}
+
+	if _, _, err := build(false); err == nil {
+		t.Errorf("Expected error for truncated image")
+	}
+
+	res, header, err := build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Total != 2 || len(res.Rejects) != 2 || res.Rejects[0].Index != 1 || res.Rejects[1].Index != 3 {
+		t.Fatalf("Incorrect rejects: got total %d and %d rejects", res.Total, len(res.Rejects))
+	}
+
+	var buf strings.Builder
+	if err := WriteRejects(&buf, header, res.Rejects); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][0] != bad || len(rows[1][len(rows[1])-1]) == 0 || rows[2][0] != malformed {
+		t.Errorf("Invalid rejects CSV: got %v", rows)
+	}
+}
+
+func TestSummaryPixels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	train := buildImages(t, dir, 5, nil)
+
+	sum, err := Summary(context.Background(), train, &SummaryOptions{Pixels: true, PixelChannels: 1, Threads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Image i is (8+i)x8 with 8+i pixels of value 20*i
+	total, count := 0.0, 0.0
+	for i := 1; i <= 5; i++ {
+		total += float64((8 + i) * 20 * i)
+		count += float64((8 + i) * 8)
+	}
+
+	s := sum.Stats
+	if s.Pixels == nil || s.Pixels.Images != 5 {
+		t.Fatalf("Missing pixel statistics")
+	}
+	if math.Abs(s.Pixels.Mean[0]-total/count) > 1e-9 {
+		t.Errorf("Incorrect mean: got %f should be %f", s.Pixels.Mean[0], total/count)
+	}
+	if s.LabelPixels["Crystals"] == nil || s.LabelPixels["Crystals"].Images != 2 {
+		t.Errorf("Incorrect label pixel statistics: %v", s.LabelPixels)
+	}
+	if len(s.SourcePixels) != 3 {
+		t.Errorf("Incorrect number of sources: got %d should be %d", len(s.SourcePixels), 3)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := s.WriteHistograms(buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+1+3+2 || len(rows[0]) != 259 {
+		t.Errorf("Incorrect histogram CSV: got %d rows", len(rows))
+	}
+}
@@ -0,0 +1,559 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// DedupeOptions are the options for finding duplicate images
+type DedupeOptions struct {
+	// Path of a second dataset. If set only duplicates between the two
+	// datasets are reported
+	Other string
+
+	// Name of the perceptual hash function. Defaults to dhash. Hashes stored
+	// in the Example protos are used if present
+	Hash string
+
+	// Maximum Hamming distance between the perceptual hashes of near
+	// duplicates. If negative only exact duplicates are found
+	Distance int
+
+	// Number of files to read concurrently. Defaults to the number of CPUs
+	Threads int
+
+	// Input files use zlib compression
+	Compress bool
+
+	// Profile used to read the Example protos
+	Profile *terf.Profile
+
+	// Continue with the remaining files if a file fails. Failed files are
+	// reported in DedupeResult.Errors
+	KeepGoing bool
+
+	// Called with FileStarted, FileDone and FileFailed progress events
+	Progress ProgressFunc
+}
+
+// Duplicate is an image that belongs to a group of duplicates
+type Duplicate struct {
+	// Dataset path the image was read from
+	Dataset string
+
+	// TFRecords file containing the image
+	File string
+
+	// Index of the record in File starting at 0
+	Record int
+
+	// Image ID
+	ID int
+
+	// SHA-256 of the image data
+	Key string
+
+	// Perceptual hash of the image
+	Hash uint64
+
+	// Another image in the group has the same Key
+	Exact bool
+
+	// Image is dropped when rewriting the dataset. Within a single dataset
+	// all but the first image of each group are dropped. Across two datasets
+	// the images of the first dataset are dropped
+	Drop bool
+}
+
+// DuplicateGroup is a group of exact or near duplicate images ordered by
+// dataset, file and record
+type DuplicateGroup struct {
+	ID     int
+	Images []*Duplicate
+}
+
+// DedupeResult is the result of Dedupe
+type DedupeResult struct {
+	// Groups of duplicates ordered by their first image
+	Groups []*DuplicateGroup
+
+	// Files that failed when KeepGoing is set
+	Errors []*FileError
+}
+
+// Dedupe finds groups of exact and near duplicate images in the TFRecords
+// file or directory of files at inputPath. Exact duplicates have the same
+// SHA-256 key (image/key/sha256 or the SHA-256 of the encoded image) and near
+// duplicates have perceptual hashes within opts.Distance. If opts.Other is
+// set only duplicates between the two datasets are grouped.
+func Dedupe(ctx context.Context, inputPath string, opts *DedupeOptions) (*DedupeResult, error) {
+	// Hashes are stored under their lower case names, see terf.HashFuncs
+	hashName := strings.ToLower(opts.Hash)
+	if len(hashName) == 0 {
+		hashName = "dhash"
+	}
+	hashFn, err := terf.ParseHash(hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := opts.Profile
+	if profile == nil {
+		profile = terf.InceptionProfile
+	}
+
+	datasets := []string{inputPath}
+	if len(opts.Other) > 0 {
+		datasets = append(datasets, opts.Other)
+	}
+
+	images := make([]*Duplicate, 0)
+	fileErrors := make([]*FileError, 0)
+	for _, dataset := range datasets {
+		paths, err := inputFiles(dataset)
+		if err != nil {
+			return nil, err
+		}
+
+		found := make(chan []*Duplicate, len(paths))
+		errs, err := processFiles(ctx, paths, opts.Threads, opts.KeepGoing, opts.Compress, opts.Progress, func(ctx context.Context, path string) (int, error) {
+			dups := make([]*Duplicate, 0)
+			_, err := readFile(ctx, path, opts.Compress, func(ex *protobuf.Example) error {
+				d, err := dedupeImage(ex, profile, hashName, hashFn)
+				if err != nil {
+					return err
+				}
+
+				d.Dataset = dataset
+				d.File = path
+				d.Record = len(dups)
+				dups = append(dups, d)
+				return nil
+			})
+			if err != nil {
+				return 0, err
+			}
+
+			found <- dups
+			return len(dups), nil
+		})
+		close(found)
+		if err != nil {
+			return nil, err
+		}
+
+		fileErrors = append(fileErrors, errs...)
+		for dups := range found {
+			images = append(images, dups...)
+		}
+	}
+
+	dsIndex := make(map[string]int)
+	for n, ds := range datasets {
+		dsIndex[ds] = n
+	}
+
+	sort.Slice(images, func(a, b int) bool {
+		if images[a].Dataset != images[b].Dataset {
+			return dsIndex[images[a].Dataset] < dsIndex[images[b].Dataset]
+		}
+		if images[a].File != images[b].File {
+			return images[a].File < images[b].File
+		}
+		return images[a].Record < images[b].Record
+	})
+
+	groups := groupDuplicates(images, dsIndex, len(datasets) > 1, opts.Distance)
+
+	return &DedupeResult{Groups: groups, Errors: fileErrors}, nil
+}
+
+// dedupeImage returns the key and perceptual hash of the image in example
+func dedupeImage(ex *protobuf.Example, profile *terf.Profile, hashName string, hashFn terf.HashFunc) (*Duplicate, error) {
+	img := &terf.Image{Profile: profile}
+	err := img.UnmarshalExample(ex)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Duplicate{ID: img.ID, Key: img.Key}
+	if len(d.Key) == 0 {
+		sum := sha256.Sum256(img.Raw)
+		d.Key = hex.EncodeToString(sum[:])
+	}
+
+	h, ok := img.Hashes[hashName]
+	if !ok {
+		im, err := img.Decode()
+		if err != nil {
+			return nil, err
+		}
+		h = hashFn(im)
+	}
+	d.Hash = h
+
+	return d, nil
+}
+
+// groupDuplicates returns the groups of exact and near duplicates in images.
+// If cross is true only images from different datasets are linked
+func groupDuplicates(images []*Duplicate, dsIndex map[string]int, cross bool, distance int) []*DuplicateGroup {
+	parent := make([]int, len(images))
+	for n := range parent {
+		parent[n] = n
+	}
+
+	var find func(n int) int
+	find = func(n int) int {
+		if parent[n] != n {
+			parent[n] = find(parent[n])
+		}
+		return parent[n]
+	}
+
+	link := func(a, b int) {
+		if a == b || (cross && images[a].Dataset == images[b].Dataset) {
+			return
+		}
+		ra, rb := find(a), find(b)
+		if ra == rb {
+			return
+		}
+		// Keep the earliest image as the root
+		if rb < ra {
+			ra, rb = rb, ra
+		}
+		parent[rb] = ra
+	}
+
+	// Exact duplicates: link each image to the first image with the same key
+	// in each dataset
+	first := make(map[string][]int)
+	for n, d := range images {
+		if _, ok := first[d.Key]; !ok {
+			first[d.Key] = []int{-1, -1}
+		}
+		ds := dsIndex[d.Dataset]
+		if first[d.Key][ds] < 0 {
+			first[d.Key][ds] = n
+		}
+	}
+	for n, d := range images {
+		for _, f := range first[d.Key] {
+			if f >= 0 {
+				link(n, f)
+			}
+		}
+	}
+
+	// Near duplicates: two hashes within distance share at least one of
+	// distance+1 chunks (pigeonhole principle), so only images sharing a
+	// chunk are compared
+	if distance >= 0 {
+		chunks := distance + 1
+		if chunks > 64 {
+			chunks = 64
+		}
+
+		type chunkKey struct {
+			chunk int
+			val   uint64
+		}
+		index := make(map[chunkKey][]int)
+		for n, d := range images {
+			for c := 0; c < chunks; c++ {
+				lo, hi := c*64/chunks, (c+1)*64/chunks
+				mask := uint64(1)<<uint(hi-lo) - 1
+				key := chunkKey{chunk: c, val: (d.Hash >> uint(lo)) & mask}
+				for _, other := range index[key] {
+					if terf.HammingDistance(d.Hash, images[other].Hash) <= distance {
+						link(n, other)
+					}
+				}
+				index[key] = append(index[key], n)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	roots := make([]int, 0)
+	for n := range images {
+		r := find(n)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], n)
+	}
+	sort.Ints(roots)
+
+	groups := make([]*DuplicateGroup, 0)
+	for _, r := range roots {
+		m := members[r]
+		if len(m) < 2 {
+			continue
+		}
+
+		g := &DuplicateGroup{ID: len(groups) + 1, Images: make([]*Duplicate, len(m))}
+		keys := make(map[string]int)
+		for n, idx := range m {
+			g.Images[n] = images[idx]
+			keys[images[idx].Key]++
+		}
+
+		for n, d := range g.Images {
+			d.Exact = keys[d.Key] > 1
+			if cross {
+				d.Drop = dsIndex[d.Dataset] == 0
+			} else {
+				d.Drop = n > 0
+			}
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+// WriteCSV writes the duplicate groups as CSV to w with the columns group,
+// dataset, file, record, image_id, key, hash, exact and drop
+func (r *DedupeResult) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{"group", "dataset", "file", "record", "image_id", "key", "hash", "exact", "drop"})
+	if err != nil {
+		return err
+	}
+
+	for _, g := range r.Groups {
+		for _, d := range g.Images {
+			err := out.Write([]string{
+				strconv.Itoa(g.ID),
+				d.Dataset,
+				d.File,
+				strconv.Itoa(d.Record),
+				strconv.Itoa(d.ID),
+				d.Key,
+				terf.FormatHash(d.Hash),
+				strconv.FormatBool(d.Exact),
+				strconv.FormatBool(d.Drop),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Dropped returns the records of the dataset at inputPath that are dropped,
+// keyed by file and record index
+func (r *DedupeResult) Dropped(inputPath string) map[string]map[int]bool {
+	dropped := make(map[string]map[int]bool)
+	for _, g := range r.Groups {
+		for _, d := range g.Images {
+			if !d.Drop || d.Dataset != inputPath {
+				continue
+			}
+			if dropped[d.File] == nil {
+				dropped[d.File] = make(map[int]bool)
+			}
+			dropped[d.File][d.Record] = true
+		}
+	}
+
+	return dropped
+}
+
+// Rewrite writes the dataset at inputPath into new shards leaving out the
+// duplicates marked Drop. compress is set if the input files use zlib
+// compression. The output directory must differ from the directory of the
+// input files since the shards would be overwritten while they are read.
+func (r *DedupeResult) Rewrite(ctx context.Context, inputPath string, compress bool, opts *BuildOptions) (*BuildResult, error) {
+	paths, err := inputFiles(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	outdir := opts.OutDir
+	if len(outdir) == 0 {
+		outdir = "."
+	}
+	for _, path := range paths {
+		same, err := sameDir(filepath.Dir(path), outdir)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return nil, errors.New("Output directory must differ from the input directory")
+		}
+	}
+
+	dropped := r.Dropped(inputPath)
+
+	total := 0
+	for _, path := range paths {
+		n, err := readFile(ctx, path, compress, func(ex *protobuf.Example) error { return nil })
+		if err != nil {
+			return nil, err
+		}
+		total += n - len(dropped[path])
+	}
+
+	src := &recordSource{paths: paths, compress: compress, total: total, skip: dropped}
+	defer src.close()
+
+	return Build(ctx, src, opts)
+}
+
+// sameDir returns true if the paths a and b refer to the same directory. b
+// does not have to exist.
+func sameDir(a, b string) (bool, error) {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+
+	sb, err := os.Stat(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(sa, sb), nil
+}
+
+// recordSource is a Source reading the Example protos from TFRecords files in
+// order and skipping records
+type recordSource struct {
+	paths    []string
+	compress bool
+	total    int
+	skip     map[string]map[int]bool
+
+	in     *os.File
+	zin    io.ReadCloser
+	r      *terf.Reader
+	path   string
+	record int
+}
+
+// exampleRecord is an ExampleMarshaler for an already decoded Example
+type exampleRecord struct {
+	ex *protobuf.Example
+}
+
+func (e *exampleRecord) MarshalExample() (*protobuf.Example, error) {
+	return e.ex, nil
+}
+
+// Len returns the number of records that are not skipped
+func (s *recordSource) Len() (int, error) {
+	return s.total, nil
+}
+
+// Next returns the next record that is not skipped
+func (s *recordSource) Next() (terf.ExampleMarshaler, error) {
+	for {
+		if s.r == nil {
+			if len(s.paths) == 0 {
+				return nil, io.EOF
+			}
+
+			err := s.open(s.paths[0])
+			if err != nil {
+				return nil, err
+			}
+			s.paths = s.paths[1:]
+		}
+
+		ex, err := s.r.Next()
+		if err == io.EOF {
+			s.close()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := s.record
+		s.record++
+		if s.skip[s.path][record] {
+			continue
+		}
+
+		return &exampleRecord{ex: ex}, nil
+	}
+}
+
+// open opens the TFRecords file at path
+func (s *recordSource) open(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	s.in = in
+	s.path = path
+	s.record = 0
+
+	if s.compress {
+		zin, err := zlib.NewReader(in)
+		if err != nil {
+			in.Close()
+			return err
+		}
+		s.zin = zin
+		s.r = terf.NewReader(zin)
+	} else {
+		s.r = terf.NewReader(in)
+	}
+
+	return nil
+}
+
+// close closes the current file
+func (s *recordSource) close() {
+	if s.zin != nil {
+		s.zin.Close()
+		s.zin = nil
+	}
+	if s.in != nil {
+		s.in.Close()
+		s.in = nil
+	}
+	s.r = nil
+}
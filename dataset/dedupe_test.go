@@ -0,0 +1,153 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeNoise writes a PNG image of random 4x4 blocks offset by delta to path
+func writeNoise(t *testing.T, path string, seed int64, delta int) {
+	rnd := rand.New(rand.NewSource(seed))
+	im := image.NewGray(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			if x%4 == 0 && y%4 == 0 {
+				im.SetGray(x, y, color.Gray{Y: uint8(20 + rnd.Intn(180) + delta)})
+			} else {
+				im.SetGray(x, y, im.GrayAt(x-x%4, y-y%4))
+			}
+		}
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := png.Encode(out, im); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// buildCSV builds a dataset in outdir from the image paths with ids starting
+// at 1
+func buildCSV(t *testing.T, dir, outdir string, paths ...string) {
+	lines := []string{"image_path,image_id"}
+	for n, path := range paths {
+		lines = append(lines, fmt.Sprintf("%s,%d", path, n+1))
+	}
+
+	csvPath := filepath.Join(dir, filepath.Base(outdir)+".csv")
+	if err := ioutil.WriteFile(csvPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	src, err := NewImageSource(in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(context.Background(), src, &BuildOptions{OutDir: outdir, Size: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.png")
+	b := filepath.Join(dir, "b.png")
+	c := filepath.Join(dir, "c.png")
+	writeNoise(t, a, 1, 0)
+	writeNoise(t, b, 1, 10)
+	writeNoise(t, c, 2, 0)
+
+	train := filepath.Join(dir, "train")
+	buildCSV(t, dir, train, a, c, a, b)
+
+	res, err := Dedupe(context.Background(), train, &DedupeOptions{Distance: 2, Hash: "DHash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Groups) != 1 || len(res.Groups[0].Images) != 3 {
+		t.Fatalf("Incorrect duplicate groups: got %d", len(res.Groups))
+	}
+
+	g := res.Groups[0]
+	for n, want := range []struct {
+		id    int
+		exact bool
+		drop  bool
+	}{{1, true, false}, {3, true, true}, {4, false, true}} {
+		d := g.Images[n]
+		if d.ID != want.id || d.Exact != want.exact || d.Drop != want.drop {
+			t.Errorf("Invalid duplicate %d: got id=%d exact=%t drop=%t", n, d.ID, d.Exact, d.Drop)
+		}
+	}
+
+	if _, err := res.Rewrite(context.Background(), train, false, &BuildOptions{OutDir: train}); err == nil {
+		t.Errorf("Expected error for rewriting into the input directory")
+	}
+
+	out := filepath.Join(dir, "deduped")
+	build, err := res.Rewrite(context.Background(), train, false, &BuildOptions{OutDir: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Total != 2 {
+		t.Errorf("Incorrect number of records after rewrite: got %d should be %d", build.Total, 2)
+	}
+
+	test := filepath.Join(dir, "test")
+	buildCSV(t, dir, test, b)
+
+	res, err = Dedupe(context.Background(), out, &DedupeOptions{Other: test, Distance: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Groups) != 1 || len(res.Groups[0].Images) != 2 {
+		t.Fatalf("Incorrect duplicate groups across datasets: got %d", len(res.Groups))
+	}
+	if d := res.Groups[0].Images[0]; d.Dataset != out || d.ID != 1 || !d.Drop {
+		t.Errorf("Invalid duplicate across datasets: got %s id=%d drop=%t", d.Dataset, d.ID, d.Drop)
+	}
+}
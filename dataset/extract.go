@@ -0,0 +1,422 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+const (
+	// InfoFile is the name of the CSV file written by Extract
+	InfoFile = "info.csv"
+)
+
+// MultiLabelPolicy selects the output directory of multi-label images in
+// Extract
+type MultiLabelPolicy string
+
+const (
+	// MultiLabelFirst writes images to the directory of their first label.
+	// This is the default
+	MultiLabelFirst MultiLabelPolicy = "first"
+
+	// MultiLabelJoin writes images to a directory named after all labels
+	// joined with "+", for example Crystals+Precipitate
+	MultiLabelJoin MultiLabelPolicy = "join"
+
+	// MultiLabelCopy writes a copy of images to the directory of each label.
+	// The info.csv file refers to the copy in the directory of the first label
+	MultiLabelCopy MultiLabelPolicy = "copy"
+)
+
+// ParseMultiLabelPolicy returns the MultiLabelPolicy with the given name. An
+// empty name returns MultiLabelFirst
+func ParseMultiLabelPolicy(name string) (MultiLabelPolicy, error) {
+	switch MultiLabelPolicy(name) {
+	case "", MultiLabelFirst:
+		return MultiLabelFirst, nil
+	case MultiLabelJoin, MultiLabelCopy:
+		return MultiLabelPolicy(name), nil
+	}
+
+	return "", fmt.Errorf("Unknown multi-label policy %s. Valid policies are: first, join, copy", name)
+}
+
+// ExtractOptions are the options for extracting a dataset
+type ExtractOptions struct {
+	// Output directory. Required
+	OutDir string
+
+	// Number of files to extract concurrently. Defaults to the number of CPUs
+	Threads int
+
+	// Input files use zlib compression
+	Compress bool
+
+	// Profile used to unmarshal the Example protos
+	Profile *terf.Profile
+
+	// Continue extracting the remaining files if a file fails. Failed files
+	// are reported in ExtractResult.Errors
+	KeepGoing bool
+
+	// Called with FileStarted, FileDone and FileFailed progress events
+	Progress ProgressFunc
+
+	// Output directory policy for multi-label images. Defaults to
+	// MultiLabelFirst
+	MultiLabel MultiLabelPolicy
+}
+
+// ExtractResult is the result of Extract
+type ExtractResult struct {
+	// Number of images extracted
+	Images int
+
+	// Files that failed when KeepGoing is set
+	Errors []*FileError
+}
+
+// Extract writes the raw image data from the TFRecords file or directory of
+// files at inputPath into opts.OutDir. Images are written to a subdirectory
+// named after the label text with any segmentation masks next to them and an
+// info.csv file is written describing all extracted images in the format
+// accepted by Build. Multi-label images are written according to
+// opts.MultiLabel.
+func Extract(ctx context.Context, inputPath string, opts *ExtractOptions) (*ExtractResult, error) {
+	if len(opts.OutDir) == 0 {
+		return nil, errors.New("Please provide an output directory")
+	}
+
+	if _, err := ParseMultiLabelPolicy(string(opts.MultiLabel)); err != nil {
+		return nil, err
+	}
+
+	outdir, err := filepath.Abs(opts.OutDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(outdir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := inputFiles(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := newInfoWriter(outdir, opts.MultiLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer info.remove()
+
+	fileErrors, err := processFiles(ctx, paths, opts.Threads, opts.KeepGoing, opts.Compress, opts.Progress, func(ctx context.Context, path string) (int, error) {
+		im, err := extractFile(ctx, path, outdir, opts)
+		if err != nil {
+			return 0, err
+		}
+
+		err = info.write(im)
+		if err != nil {
+			return 0, err
+		}
+
+		return len(im), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ExtractResult{
+		Images: info.count,
+		Errors: fileErrors,
+	}
+
+	if len(paths) == 1 && res.Images == 0 && len(res.Errors) == 0 {
+		return nil, errors.New("No images found")
+	}
+
+	err = info.close()
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// infoColumns are the columns of the info.csv file before the Extra feature
+// columns. The optional columns are only written if used by an image
+var infoColumns = append(append([]string{}, terf.CSVHeader...), "label_confidence", "objects", "mask_path", "instance_masks")
+
+// infoWriter writes the info.csv file of extracted images. The header
+// includes the optional and Extra feature columns used by any image so rows
+// are written to a temporary file as each input file is extracted and
+// rewritten with the final header by close. Each temporary row holds the
+// number of Extra columns of the image, their names and the values of
+// infoColumns followed by the Extra columns.
+type infoWriter struct {
+	mu     sync.Mutex
+	outdir string
+	policy MultiLabelPolicy
+	tmp    *os.File
+	w      *csv.Writer
+	count  int
+
+	// Optional columns used by any image
+	used  map[string]bool
+	extra map[string]bool
+}
+
+// newInfoWriter returns a new infoWriter for the info.csv file in outdir
+func newInfoWriter(outdir string, policy MultiLabelPolicy) (*infoWriter, error) {
+	tmp, err := ioutil.TempFile(outdir, ".info-*.csv")
+	if err != nil {
+		return nil, err
+	}
+
+	return &infoWriter{
+		outdir: outdir,
+		policy: policy,
+		tmp:    tmp,
+		w:      csv.NewWriter(tmp),
+		used:   make(map[string]bool),
+		extra:  make(map[string]bool),
+	}, nil
+}
+
+// write adds the rows of the extracted images to the temporary file
+func (iw *infoWriter) write(images []*terf.Image) error {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	for _, i := range images {
+		for _, l := range i.Labels {
+			if l.Confidence != 1 {
+				iw.used["label_confidence"] = true
+			}
+		}
+		if len(i.Objects) > 0 {
+			iw.used["objects"] = true
+		}
+		if len(i.MaskFormat) > 0 {
+			iw.used["mask_path"] = true
+		}
+		if len(i.InstanceMasks) > 0 {
+			iw.used["instance_masks"] = true
+		}
+
+		extra := i.ExtraCSVHeader()
+		for _, col := range extra {
+			iw.extra[col] = true
+		}
+
+		row := append([]string{strconv.Itoa(len(extra))}, extra...)
+		row = append(row, i.MarshalCSVHeader(append(append([]string{}, infoColumns...), extra...), labelDirs(iw.outdir, i, iw.policy)[0])...)
+		if err := iw.w.Write(row); err != nil {
+			return err
+		}
+		iw.count++
+	}
+
+	iw.w.Flush()
+	return iw.w.Error()
+}
+
+// close writes the info.csv file with the header of all columns used by the
+// images and the rows of the temporary file
+func (iw *infoWriter) close() error {
+	extra := make([]string, 0, len(iw.extra))
+	for col := range iw.extra {
+		extra = append(extra, col)
+	}
+	sort.Strings(extra)
+
+	header := append([]string{}, terf.CSVHeader...)
+	columns := make([]int, 0, len(infoColumns))
+	for n, col := range infoColumns {
+		if n < len(terf.CSVHeader) || iw.used[col] {
+			columns = append(columns, n)
+		}
+		if n >= len(terf.CSVHeader) && iw.used[col] {
+			header = append(header, col)
+		}
+	}
+	header = append(header, extra...)
+
+	_, err := iw.tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(filepath.Join(iw.outdir, InfoFile))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := csv.NewWriter(out)
+	err = w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	r := csv.NewReader(iw.tmp)
+	r.FieldsPerRecord = -1
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		k, err := strconv.Atoi(rec[0])
+		if err != nil || len(rec) != 1+2*k+len(infoColumns) {
+			return errors.New("Invalid temporary info row")
+		}
+		names, values := rec[1:1+k], rec[1+k:]
+
+		row := make([]string, 0, len(header))
+		for _, n := range columns {
+			row = append(row, values[n])
+		}
+
+		byName := make(map[string]string, k)
+		for n, name := range names {
+			byName[name] = values[len(infoColumns)+n]
+		}
+		for _, col := range extra {
+			row = append(row, byName[col])
+		}
+
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// remove closes and removes the temporary file
+func (iw *infoWriter) remove() {
+	iw.tmp.Close()
+	os.Remove(iw.tmp.Name())
+}
+
+// labelDirs returns the output directories for Image i. Images have a single
+// directory unless policy is MultiLabelCopy. The first directory is used in
+// the info.csv file
+func labelDirs(outdir string, i *terf.Image, policy MultiLabelPolicy) []string {
+	if len(i.Labels) > 0 {
+		names := make([]string, len(i.Labels))
+		for n, l := range i.Labels {
+			names[n] = l.Text
+		}
+
+		switch policy {
+		case MultiLabelJoin:
+			return []string{filepath.Join(outdir, strings.Join(names, "+"))}
+		case MultiLabelCopy:
+			dirs := make([]string, len(names))
+			for n, name := range names {
+				dirs[n] = filepath.Join(outdir, name)
+			}
+			return dirs
+		}
+	}
+
+	if len(i.LabelText) > 0 {
+		return []string{filepath.Join(outdir, i.LabelText)}
+	}
+
+	return []string{outdir}
+}
+
+func extractFile(ctx context.Context, inputPath, outdir string, opts *ExtractOptions) ([]*terf.Image, error) {
+	images := make([]*terf.Image, 0)
+
+	_, err := readFile(ctx, inputPath, opts.Compress, func(ex *protobuf.Example) error {
+		img := &terf.Image{Profile: opts.Profile}
+		err := img.UnmarshalExample(ex)
+		if err != nil {
+			return err
+		}
+
+		// Pixel data is rebuilt into a viewable image
+		if img.Format == terf.RawFormat {
+			err = img.Convert(terf.ConvertPNG)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, dir := range labelDirs(outdir, img, opts.MultiLabel) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+
+			err = img.Save(filepath.Join(dir, img.Name()))
+			if err != nil {
+				return err
+			}
+
+			err = img.SaveMasks(dir)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Release image data. MaskFormat and the number of InstanceMasks are
+		// kept for the info file
+		img.Raw = nil
+		img.Mask = nil
+		for n := range img.InstanceMasks {
+			img.InstanceMasks[n] = nil
+		}
+		images = append(images, img)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
@@ -0,0 +1,318 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"encoding/csv"
+	"errors"
+	"image"
+	"io"
+	"time"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// ImageOptions are the options for converting CSV rows into terf Images
+type ImageOptions struct {
+	// Profile used to marshal the Example protos
+	Profile *terf.Profile
+
+	// Conversion target for images. Defaults to terf.ConvertNone
+	Convert terf.Conversion
+
+	// JPEG quality (1-100). If 0, jpeg.DefaultQuality is used
+	JPEGQuality int
+
+	// Feature type for the pixels of images converted with terf.ConvertRaw.
+	// Defaults to terf.PixelBytes
+	PixelEncoding terf.PixelEncoding
+
+	// Do not re-encode images that are already JPEG in the target colorspace
+	// when no transforms are applied
+	SkipReencode bool
+
+	// Transforms applied to each image before encoding
+	Transforms []terf.Transform
+
+	// Store the source path and modification time of image files
+	SourceInfo bool
+
+	// Remove EXIF, XMP, ICC profiles and comments from images. TIFF and GIF
+	// images are re-encoded as PNG. See terf.Image.StripMetadata
+	StripMetadata bool
+
+	// Store the named groups of a regular expression matched against the
+	// file name of each image as Extra features. See terf.FilenamePattern
+	Pattern *terf.FilenamePattern
+
+	// Names of EXIF fields to store as Extra features before the metadata is
+	// stripped or the image converted. See terf.EXIFFieldNames
+	EXIF []string
+
+	// Fully decode each image before conversion and reject truncated, empty
+	// or undecodable images and images whose file extension does not match
+	// their format. See terf.Image.Validate
+	Validate bool
+
+	// Names of perceptual hashes to compute and store, see terf.HashFuncs.
+	// Hashes are computed after the image is converted
+	Hashes []string
+
+	// Page (starting at 0) of multi-page TIFF images. A page column in the CSV
+	// file overrides this per image
+	Page int
+
+	// Split each image into tiles after the transforms. Each tile is written
+	// as a separate Example. See terf.Image.Tiles
+	Tile *terf.TileOptions
+
+	// Write random augmented copies of each image after the original. The
+	// augmentations are applied after the transforms and before tiling.
+	// Copies keep the image ID and filename of the original and are tagged
+	// with image/augment/index. See terf.Image.Augment
+	Augment *terf.AugmentOptions
+}
+
+// ImageRecord is a CSV row describing a terf Image. The image file is read
+// and converted when the record is marshalled. If the row sets a crop box it
+// is applied before the Transforms in ImageOptions.
+type ImageRecord struct {
+	Header  []string
+	Row     []string
+	Options *ImageOptions
+}
+
+// NewImageSource returns a CSVSource of ImageRecords reading from in. See
+// terf.Image.UnmarshalCSVHeader for the CSV format.
+func NewImageSource(in io.ReadSeeker, opts *ImageOptions) (*CSVSource, error) {
+	if opts == nil {
+		opts = &ImageOptions{}
+	}
+
+	src, err := NewCSVSource(in, func(header, row []string) (terf.ExampleMarshaler, error) {
+		return &ImageRecord{Header: header, Row: row, Options: opts}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sanity check
+	if src.Header()[0] != "image_path" {
+		return nil, errors.New("Invalid header")
+	}
+
+	return src, nil
+}
+
+// Image reads the image file and returns the converted terf Image. Tile in
+// ImageOptions is ignored, see Images.
+func (r *ImageRecord) Image() (*terf.Image, error) {
+	img, transforms, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	err = img.Convert(r.Options.Convert, transforms...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = img.ComputeHashes(r.Options.Hashes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// Images reads the image file and returns the converted terf Image followed
+// by its augmented copies if Augment is set in ImageOptions. If Tile is set
+// the tiles of each image are returned instead. Images can return no images
+// if the image is smaller than a tile.
+func (r *ImageRecord) Images() ([]*terf.Image, error) {
+	if r.Options.Tile == nil && r.Options.Augment == nil {
+		img, err := r.Image()
+		if err != nil {
+			return nil, err
+		}
+
+		return []*terf.Image{img}, nil
+	}
+
+	img, transforms, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []*terf.Image{img}
+	sourceTransforms := [][]terf.Transform{transforms}
+
+	if opts := r.Options.Augment; opts != nil {
+		for n := 1; n <= opts.CopiesFor(img.LabelID); n++ {
+			aug, t, err := img.Augment(opts.Sample(img.ID, n), n)
+			if err != nil {
+				return nil, err
+			}
+
+			sources = append(sources, aug)
+			sourceTransforms = append(sourceTransforms, append(append([]terf.Transform{}, transforms...), t))
+		}
+	}
+
+	images := make([]*terf.Image, 0, len(sources))
+	for n, src := range sources {
+		if r.Options.Tile != nil {
+			tiles, err := src.Tiles(r.Options.Tile, r.Options.Convert, sourceTransforms[n]...)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, tiles...)
+			continue
+		}
+
+		err = src.Convert(r.Options.Convert, sourceTransforms[n]...)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, src)
+	}
+
+	for _, i := range images {
+		err = i.ComputeHashes(r.Options.Hashes...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// read reads and validates the image file and returns the terf Image with the
+// transforms to apply
+func (r *ImageRecord) read() (*terf.Image, []terf.Transform, error) {
+	img := &terf.Image{
+		Profile:       r.Options.Profile,
+		JPEGQuality:   r.Options.JPEGQuality,
+		SkipReencode:  r.Options.SkipReencode,
+		Page:          r.Options.Page,
+		PixelEncoding: r.Options.PixelEncoding,
+	}
+	err := img.UnmarshalCSVHeader(r.Header, r.Row)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.Options.Validate {
+		err = img.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if r.Options.Pattern != nil {
+		err = img.ApplyPattern(r.Options.Pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = img.CaptureEXIF(r.Options.EXIF...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if r.Options.StripMetadata {
+		err = img.StripMetadata()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !r.Options.SourceInfo {
+		img.SourcePath = ""
+		img.ModTime = time.Time{}
+	}
+
+	transforms := r.Options.Transforms
+	if !img.CropBox.Empty() {
+		transforms = append([]terf.Transform{terf.Crop(img.CropBox)}, transforms...)
+		img.CropBox = image.Rectangle{}
+	}
+
+	return img, transforms, nil
+}
+
+// MarshalExample reads the image file and converts it to a TensorFlow Example
+// proto
+func (r *ImageRecord) MarshalExample() (*protobuf.Example, error) {
+	img, err := r.Image()
+	if err != nil {
+		return nil, err
+	}
+
+	return img.MarshalExample()
+}
+
+// MarshalExamples reads the image file and converts it or its tiles to
+// TensorFlow Example protos
+func (r *ImageRecord) MarshalExamples() ([]*protobuf.Example, error) {
+	images, err := r.Images()
+	if err != nil {
+		return nil, err
+	}
+
+	examples := make([]*protobuf.Example, len(images))
+	for n, img := range images {
+		examples[n], err = img.MarshalExample()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return examples, nil
+}
+
+// WriteRejects writes the CSV rows of rejected ImageRecords and
+// MalformedRecords to w with an extra reason column. header is the header of
+// the CSV source.
+func WriteRejects(w io.Writer, header []string, rejects []*RecordError) error {
+	out := csv.NewWriter(w)
+	err := out.Write(append(append([]string{}, header...), "reason"))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rejects {
+		row := make([]string, len(header))
+		switch rec := r.Record.(type) {
+		case *ImageRecord:
+			copy(row, rec.Row)
+		case *MalformedRecord:
+			copy(row, rec.Row)
+		}
+
+		err := out.Write(append(row, r.Err.Error()))
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
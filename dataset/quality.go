@@ -0,0 +1,291 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// QualityOptions are the options for measuring image quality
+type QualityOptions struct {
+	// Modified z-score (0.6745 * deviation from the median / median absolute
+	// deviation) above which a metric is an outlier. Defaults to 3.5
+	Threshold float64
+
+	// Minimum number of images of a source for its images to be flagged.
+	// Defaults to 5
+	MinImages int
+
+	// Number of files to read concurrently. Defaults to the number of CPUs
+	Threads int
+
+	// Input files use zlib compression
+	Compress bool
+
+	// Profile used to read the Example protos
+	Profile *terf.Profile
+
+	// Continue with the remaining files if a file fails. Failed files are
+	// reported in QualityResult.Errors
+	KeepGoing bool
+
+	// Called with FileStarted, FileDone and FileFailed progress events
+	Progress ProgressFunc
+}
+
+// ImageQuality is the quality of an image in a dataset
+type ImageQuality struct {
+	// TFRecords file containing the image
+	File string
+
+	// Index of the record in File starting at 0
+	Record int
+
+	// Image ID
+	ID int
+
+	// Source ID of the image
+	SourceID int
+
+	// Base filename of the original image
+	Filename string
+
+	// Quality indicators of the image
+	Quality *terf.Quality
+
+	// Outliers among the images of the same source: blurry, dark, bright,
+	// low_contrast, saturated, small_file or large_file
+	Flags []string
+}
+
+// QualityResult is the result of Quality
+type QualityResult struct {
+	// Images ordered by file and record
+	Images []*ImageQuality
+
+	// Files that failed when KeepGoing is set
+	Errors []*FileError
+}
+
+// qualityMetric is a quality indicator checked for outliers. low and high
+// are the flags for outliers below and above the median, an empty flag is
+// not checked. Deviations are at least minScale so sources of near identical
+// images do not flag tiny differences.
+type qualityMetric struct {
+	value    func(q *terf.Quality) float64
+	low      string
+	high     string
+	minScale float64
+}
+
+// qualityMetrics are the metrics checked for outliers. The Laplacian variance
+// and file size are heavy tailed and compared on a log scale.
+var qualityMetrics = []qualityMetric{
+	{func(q *terf.Quality) float64 { return math.Log1p(q.Sharpness) }, "blurry", "", 0.1},
+	{func(q *terf.Quality) float64 { return q.Brightness }, "dark", "bright", 1},
+	{func(q *terf.Quality) float64 { return q.Contrast }, "low_contrast", "", 1},
+	{func(q *terf.Quality) float64 { return q.Saturated }, "", "saturated", 0.01},
+	{func(q *terf.Quality) float64 { return math.Log1p(float64(q.FileSize)) }, "small_file", "large_file", 0.05},
+}
+
+// Quality measures the quality of each image in the TFRecords file or
+// directory of files at inputPath and flags statistical outliers among the
+// images of each source. See terf.MeasureQuality.
+func Quality(ctx context.Context, inputPath string, opts *QualityOptions) (*QualityResult, error) {
+	paths, err := inputFiles(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := opts.Profile
+	if profile == nil {
+		profile = terf.InceptionProfile
+	}
+
+	found := make(chan []*ImageQuality, len(paths))
+	fileErrors, err := processFiles(ctx, paths, opts.Threads, opts.KeepGoing, opts.Compress, opts.Progress, func(ctx context.Context, path string) (int, error) {
+		images := make([]*ImageQuality, 0)
+		_, err := readFile(ctx, path, opts.Compress, func(ex *protobuf.Example) error {
+			img := &terf.Image{Profile: profile}
+			err := img.UnmarshalExample(ex)
+			if err != nil {
+				return err
+			}
+
+			im, err := img.Decode()
+			if err != nil {
+				return err
+			}
+
+			images = append(images, &ImageQuality{
+				File:     path,
+				Record:   len(images),
+				ID:       img.ID,
+				SourceID: img.SourceID,
+				Filename: img.Filename,
+				Quality:  terf.MeasureQuality(im, len(img.Raw)),
+			})
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		found <- images
+		return len(images), nil
+	})
+	close(found)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &QualityResult{Images: make([]*ImageQuality, 0), Errors: fileErrors}
+	for images := range found {
+		res.Images = append(res.Images, images...)
+	}
+
+	sort.Slice(res.Images, func(a, b int) bool {
+		if res.Images[a].File != res.Images[b].File {
+			return res.Images[a].File < res.Images[b].File
+		}
+		return res.Images[a].Record < res.Images[b].Record
+	})
+
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = 3.5
+	}
+
+	minImages := opts.MinImages
+	if minImages <= 0 {
+		minImages = 5
+	}
+
+	sources := make(map[int][]*ImageQuality)
+	for _, i := range res.Images {
+		sources[i.SourceID] = append(sources[i.SourceID], i)
+	}
+	for _, images := range sources {
+		if len(images) >= minImages {
+			flagOutliers(images, threshold)
+		}
+	}
+
+	return res, nil
+}
+
+// flagOutliers flags the images whose quality metrics have a modified z-score
+// above threshold
+func flagOutliers(images []*ImageQuality, threshold float64) {
+	for _, m := range qualityMetrics {
+		vals := make([]float64, len(images))
+		for n, i := range images {
+			vals[n] = m.value(i.Quality)
+		}
+
+		med := median(vals)
+		dev := make([]float64, len(vals))
+		for n, v := range vals {
+			dev[n] = math.Abs(v - med)
+		}
+		scale := math.Max(median(dev), m.minScale)
+
+		for n, i := range images {
+			z := 0.6745 * (vals[n] - med) / scale
+			if z < -threshold && len(m.low) > 0 {
+				i.Flags = append(i.Flags, m.low)
+			}
+			if z > threshold && len(m.high) > 0 {
+				i.Flags = append(i.Flags, m.high)
+			}
+		}
+	}
+}
+
+// median returns the median of vals. vals is not modified
+func median(vals []float64) float64 {
+	sorted := append([]float64{}, vals...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Flagged returns the images with at least one flag
+func (r *QualityResult) Flagged() []*ImageQuality {
+	flagged := make([]*ImageQuality, 0)
+	for _, i := range r.Images {
+		if len(i.Flags) > 0 {
+			flagged = append(flagged, i)
+		}
+	}
+
+	return flagged
+}
+
+// WriteCSV writes the quality of the flagged images, or of all images if all
+// is set, as CSV to w with the columns file, record, image_id, source,
+// filename, sharpness, brightness, contrast, saturated, file_size and flags.
+// Flags are separated by semicolons.
+func (r *QualityResult) WriteCSV(w io.Writer, all bool) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{"file", "record", "image_id", "source", "filename", "sharpness", "brightness", "contrast", "saturated", "file_size", "flags"})
+	if err != nil {
+		return err
+	}
+
+	images := r.Images
+	if !all {
+		images = r.Flagged()
+	}
+
+	for _, i := range images {
+		err := out.Write([]string{
+			i.File,
+			strconv.Itoa(i.Record),
+			strconv.Itoa(i.ID),
+			strconv.Itoa(i.SourceID),
+			i.Filename,
+			strconv.FormatFloat(i.Quality.Sharpness, 'f', 2, 64),
+			strconv.FormatFloat(i.Quality.Brightness, 'f', 2, 64),
+			strconv.FormatFloat(i.Quality.Contrast, 'f', 2, 64),
+			strconv.FormatFloat(i.Quality.Saturated, 'f', 4, 64),
+			strconv.Itoa(i.Quality.FileSize),
+			strings.Join(i.Flags, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
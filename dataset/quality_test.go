@@ -0,0 +1,91 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuality(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := make([]string, 0)
+	for n := 0; n < 6; n++ {
+		path := filepath.Join(dir, fmt.Sprintf("noise%d.png", n))
+		writeNoise(t, path, int64(n), 0)
+		paths = append(paths, path)
+	}
+
+	black := filepath.Join(dir, "black.png")
+	out, err := os.Create(black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(out, image.NewGray(image.Rect(0, 0, 32, 24))); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	paths = append(paths, black)
+
+	train := filepath.Join(dir, "train")
+	buildCSV(t, dir, train, paths...)
+
+	// Few random images have a wide spread, use a higher threshold
+	res, err := Quality(context.Background(), train, &QualityOptions{Threshold: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Images) != 7 {
+		t.Fatalf("Incorrect number of images: got %d should be %d", len(res.Images), 7)
+	}
+
+	flagged := res.Flagged()
+	if len(flagged) != 1 || flagged[0].ID != 7 {
+		t.Fatalf("Expected only the black image to be flagged: got %d", len(flagged))
+	}
+
+	flags := make(map[string]bool)
+	for _, f := range flagged[0].Flags {
+		flags[f] = true
+	}
+	for _, f := range []string{"blurry", "dark", "low_contrast", "saturated"} {
+		if !flags[f] {
+			t.Errorf("Missing flag %s: got %v", f, flagged[0].Flags)
+		}
+	}
+
+	res, err = Quality(context.Background(), train, &QualityOptions{MinImages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Flagged()) != 0 {
+		t.Errorf("Expected no flags for sources with fewer than MinImages images")
+	}
+}
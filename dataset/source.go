@@ -0,0 +1,207 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// Source is the interface that provides the records of a dataset. Next
+// returns the next record or io.EOF when no records remain. Next is called
+// from a single goroutine while the MarshalExample method of the returned
+// records is called concurrently, so any expensive work (reading files,
+// decoding images) should be deferred to MarshalExample.
+type Source interface {
+	Next() (terf.ExampleMarshaler, error)
+}
+
+// Lener is implemented by a Source that knows the total number of records in
+// advance. Sources that do not implement Lener are read into memory before
+// building so the total number of shards can be computed.
+type Lener interface {
+	Len() (int, error)
+}
+
+// SliceSource is a Source for a slice of records
+type SliceSource struct {
+	records []terf.ExampleMarshaler
+	next    int
+}
+
+// NewSliceSource returns a new SliceSource for records
+func NewSliceSource(records []terf.ExampleMarshaler) *SliceSource {
+	return &SliceSource{records: records}
+}
+
+// Next returns the next record
+func (s *SliceSource) Next() (terf.ExampleMarshaler, error) {
+	if s.next >= len(s.records) {
+		return nil, io.EOF
+	}
+
+	rec := s.records[s.next]
+	s.next++
+	return rec, nil
+}
+
+// Len returns the total number of records
+func (s *SliceSource) Len() (int, error) {
+	return len(s.records), nil
+}
+
+// CSVRecordFunc converts a CSV row into a record. header is the header row of
+// the CSV file.
+type CSVRecordFunc func(header, row []string) (terf.ExampleMarshaler, error)
+
+// MalformedRecord is returned by CSVSource for rows that can not be parsed,
+// for example rows with the wrong number of fields. MarshalExample returns
+// the parse error so Build rejects the row when KeepGoing is set.
+type MalformedRecord struct {
+	// Fields of the row if they could be read
+	Row []string
+
+	Err error
+}
+
+// MarshalExample returns the parse error of the row
+func (r *MalformedRecord) MarshalExample() (*protobuf.Example, error) {
+	return nil, r.Err
+}
+
+// CSVSource is a Source for CSV files with a header row. Each row is converted
+// into a record using a CSVRecordFunc. Rows that can not be parsed are
+// returned as MalformedRecords.
+type CSVSource struct {
+	in     io.ReadSeeker
+	reader *csv.Reader
+	header []string
+	fn     CSVRecordFunc
+}
+
+// NewCSVSource returns a new CSVSource reading from in. The header row is
+// parsed and passed to fn along with each row.
+func NewCSVSource(in io.ReadSeeker, fn CSVRecordFunc) (*CSVSource, error) {
+	r := csv.NewReader(in)
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSVSource{
+		in:     in,
+		reader: r,
+		header: header,
+		fn:     fn,
+	}, nil
+}
+
+// Header returns the header row of the CSV file
+func (s *CSVSource) Header() []string {
+	return s.header
+}
+
+// Next returns the record for the next CSV row
+func (s *CSVSource) Next() (terf.ExampleMarshaler, error) {
+	row, err := s.reader.Read()
+	if perr, ok := err.(*csv.ParseError); ok {
+		return &MalformedRecord{Row: row, Err: perr}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.fn(s.header, row)
+}
+
+// Len returns the number of rows in the CSV file, not including the header
+// row. The underlying reader is restored to its current position.
+func (s *CSVSource) Len() (int, error) {
+	pos, err := s.in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.in.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := lineCounter(s.in)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.in.Seek(pos, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func lineCounter(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	buf := make([]byte, 32*1024)
+	count := 0
+	lineSep := []byte{'\n'}
+
+	for {
+		c, err := reader.Read(buf)
+		count += bytes.Count(buf[:c], lineSep)
+
+		switch {
+		case err == io.EOF:
+			// Skip required header row
+			count--
+			if count <= 0 {
+				return count, errors.New("No lines found")
+			}
+
+			return count, nil
+		case err != nil:
+			return count, err
+		}
+	}
+
+}
+
+// readAll reads all records from src into a SliceSource
+func readAll(src Source) (*SliceSource, error) {
+	records := make([]terf.ExampleMarshaler, 0)
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	return NewSliceSource(records), nil
+}
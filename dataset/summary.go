@@ -0,0 +1,455 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package dataset
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/ubccr/terf"
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// Stats are summary statistics for a dataset
+type Stats struct {
+	Total      int
+	Source     map[int]int
+	LabelID    map[int]int
+	LabelRaw   map[int]int
+	LabelText  map[string]int
+	Format     map[string]int
+	Colorspace map[string]int
+	Channels   map[int]int
+
+	// Number of bounding boxes per object class
+	ObjectID   map[int]int
+	ObjectText map[string]int
+
+	// Number of multi-label images per pair of label texts. Pairs are sorted
+	CoOccurrence map[[2]string]int
+
+	// Pixel statistics of all images, by source ID and by label text. Only
+	// computed when SummaryOptions.Pixels is set. Multi-label images count
+	// toward each of their labels
+	Pixels       *terf.PixelStats
+	SourcePixels map[int]*terf.PixelStats
+	LabelPixels  map[string]*terf.PixelStats
+}
+
+// NewStats returns new empty Stats
+func NewStats() *Stats {
+	return &Stats{
+		Source:     make(map[int]int),
+		LabelID:    make(map[int]int),
+		LabelRaw:   make(map[int]int),
+		LabelText:  make(map[string]int),
+		Format:     make(map[string]int),
+		Colorspace: make(map[string]int),
+		Channels:   make(map[int]int),
+		ObjectID:   make(map[int]int),
+		ObjectText: make(map[string]int),
+
+		CoOccurrence: make(map[[2]string]int),
+		SourcePixels: make(map[int]*terf.PixelStats),
+		LabelPixels:  make(map[string]*terf.PixelStats),
+	}
+}
+
+// Add adds the counts in from to s
+func (s *Stats) Add(from *Stats) {
+	s.Total += from.Total
+	for key, val := range from.Source {
+		s.Source[key] += val
+	}
+	for key, val := range from.LabelID {
+		s.LabelID[key] += val
+	}
+	for key, val := range from.LabelRaw {
+		s.LabelRaw[key] += val
+	}
+	for key, val := range from.LabelText {
+		s.LabelText[key] += val
+	}
+	for key, val := range from.Format {
+		s.Format[key] += val
+	}
+	for key, val := range from.Colorspace {
+		s.Colorspace[key] += val
+	}
+	for key, val := range from.Channels {
+		s.Channels[key] += val
+	}
+	for key, val := range from.ObjectID {
+		s.ObjectID[key] += val
+	}
+	for key, val := range from.ObjectText {
+		s.ObjectText[key] += val
+	}
+	for key, val := range from.CoOccurrence {
+		s.CoOccurrence[key] += val
+	}
+	if from.Pixels != nil {
+		if s.Pixels == nil {
+			s.Pixels = terf.NewPixelStats(from.Pixels.Channels)
+		}
+		s.Pixels.Merge(from.Pixels)
+	}
+	for key, val := range from.SourcePixels {
+		if _, ok := s.SourcePixels[key]; !ok {
+			s.SourcePixels[key] = terf.NewPixelStats(val.Channels)
+		}
+		s.SourcePixels[key].Merge(val)
+	}
+	for key, val := range from.LabelPixels {
+		if _, ok := s.LabelPixels[key]; !ok {
+			s.LabelPixels[key] = terf.NewPixelStats(val.Channels)
+		}
+		s.LabelPixels[key].Merge(val)
+	}
+}
+
+// addPixels adds the pixels of im to the pixel statistics of s for the
+// source and labels of the image
+func (s *Stats) addPixels(im image.Image, channels, source int, labels []string) {
+	// Accumulate the image once and merge it into each breakdown
+	one := terf.NewPixelStats(channels)
+	one.AddImage(im)
+
+	if s.Pixels == nil {
+		s.Pixels = terf.NewPixelStats(channels)
+	}
+	s.Pixels.Merge(one)
+
+	if _, ok := s.SourcePixels[source]; !ok {
+		s.SourcePixels[source] = terf.NewPixelStats(channels)
+	}
+	s.SourcePixels[source].Merge(one)
+
+	for _, label := range labels {
+		if _, ok := s.LabelPixels[label]; !ok {
+			s.LabelPixels[label] = terf.NewPixelStats(channels)
+		}
+		s.LabelPixels[label].Merge(one)
+	}
+}
+
+// Print writes the Stats to w in a human-readable format
+func (s *Stats) Print(w io.Writer) {
+	fmt.Fprintf(w, "Total: %d\n", s.Total)
+	fmt.Fprintf(w, "Label: \n")
+	for key, val := range s.LabelText {
+		fmt.Fprintf(w, "    - %s: %d\n", key, val)
+	}
+
+	if len(s.Source) > 0 {
+		fmt.Fprintf(w, "Source: \n")
+		for key, val := range s.Source {
+			fmt.Fprintf(w, "    - %d: %d\n", key, val)
+		}
+	}
+
+	if len(s.LabelID) > 0 {
+		fmt.Fprintf(w, "Label ID: \n")
+		for key, val := range s.LabelID {
+			fmt.Fprintf(w, "    - %d: %d\n", key, val)
+		}
+	}
+
+	if len(s.LabelRaw) > 0 {
+		fmt.Fprintf(w, "Label Raw: \n")
+		for key, val := range s.LabelRaw {
+			fmt.Fprintf(w, "    - %d: %d\n", key, val)
+		}
+	}
+	if len(s.Format) > 0 {
+		fmt.Fprintf(w, "Format: \n")
+		for key, val := range s.Format {
+			fmt.Fprintf(w, "    - %s: %d\n", key, val)
+		}
+	}
+	if len(s.Colorspace) > 0 {
+		fmt.Fprintf(w, "Colorspace: \n")
+		for key, val := range s.Colorspace {
+			fmt.Fprintf(w, "    - %s: %d\n", key, val)
+		}
+	}
+	if len(s.Channels) > 0 {
+		fmt.Fprintf(w, "Channels: \n")
+		for key, val := range s.Channels {
+			fmt.Fprintf(w, "    - %d: %d\n", key, val)
+		}
+	}
+	if len(s.ObjectID) > 0 {
+		fmt.Fprintf(w, "Object ID: \n")
+		for key, val := range s.ObjectID {
+			fmt.Fprintf(w, "    - %d: %d\n", key, val)
+		}
+	}
+	if len(s.ObjectText) > 0 {
+		fmt.Fprintf(w, "Object: \n")
+		for key, val := range s.ObjectText {
+			fmt.Fprintf(w, "    - %s: %d\n", key, val)
+		}
+	}
+	if len(s.CoOccurrence) > 0 {
+		fmt.Fprintf(w, "Label Co-occurrence: \n")
+		for key, val := range s.CoOccurrence {
+			fmt.Fprintf(w, "    - %s, %s: %d\n", key[0], key[1], val)
+		}
+	}
+	if s.Pixels != nil {
+		fmt.Fprintf(w, "Pixel Mean/Std: \n")
+		fmt.Fprintf(w, "    - all: %s\n", pixelString(s.Pixels))
+	}
+	if len(s.SourcePixels) > 0 {
+		fmt.Fprintf(w, "Pixel Mean/Std by Source: \n")
+		for key, val := range s.SourcePixels {
+			fmt.Fprintf(w, "    - %d: %s\n", key, pixelString(val))
+		}
+	}
+	if len(s.LabelPixels) > 0 {
+		fmt.Fprintf(w, "Pixel Mean/Std by Label: \n")
+		for key, val := range s.LabelPixels {
+			fmt.Fprintf(w, "    - %s: %s\n", key, pixelString(val))
+		}
+	}
+}
+
+// pixelString returns the per-channel mean and standard deviation of p
+func pixelString(p *terf.PixelStats) string {
+	return fmt.Sprintf("mean %.3f std %.3f", p.Mean, p.Std())
+}
+
+// WriteHistograms writes the pixel histograms of s to w in CSV format. Each
+// row is the 256 bin histogram of one channel of all images (group all), a
+// source (group source) or a label (group label).
+func (s *Stats) WriteHistograms(w io.Writer) error {
+	out := csv.NewWriter(w)
+
+	header := []string{"group", "key", "channel"}
+	for v := 0; v < 256; v++ {
+		header = append(header, strconv.Itoa(v))
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	write := func(group, key string, p *terf.PixelStats) error {
+		for ch, hist := range p.Histogram {
+			row := []string{group, key, strconv.Itoa(ch)}
+			for _, n := range hist {
+				row = append(row, strconv.FormatInt(n, 10))
+			}
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if s.Pixels != nil {
+		if err := write("all", "", s.Pixels); err != nil {
+			return err
+		}
+	}
+
+	sources := make([]int, 0, len(s.SourcePixels))
+	for key := range s.SourcePixels {
+		sources = append(sources, key)
+	}
+	sort.Ints(sources)
+	for _, key := range sources {
+		if err := write("source", strconv.Itoa(key), s.SourcePixels[key]); err != nil {
+			return err
+		}
+	}
+
+	labels := make([]string, 0, len(s.LabelPixels))
+	for key := range s.LabelPixels {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+	for _, key := range labels {
+		if err := write("label", key, s.LabelPixels[key]); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// SummaryOptions are the options for summarizing a dataset
+type SummaryOptions struct {
+	// Number of files to read concurrently. Defaults to the number of CPUs
+	Threads int
+
+	// Input files use zlib compression
+	Compress bool
+
+	// Profile used to read the Example protos
+	Profile *terf.Profile
+
+	// Continue with the remaining files if a file fails. Failed files are
+	// reported in SummaryResult.Errors
+	KeepGoing bool
+
+	// Called with FileStarted, FileDone and FileFailed progress events
+	Progress ProgressFunc
+
+	// Decode every image and compute per-channel pixel statistics, see
+	// Stats.Pixels
+	Pixels bool
+
+	// Number of channels (1 or 3) of the pixel statistics. Defaults to 3
+	PixelChannels int
+}
+
+// SummaryResult is the result of Summary
+type SummaryResult struct {
+	Stats *Stats
+
+	// Files that failed when KeepGoing is set
+	Errors []*FileError
+}
+
+// Summary computes summary statistics for the TFRecords file or directory of
+// files at inputPath
+func Summary(ctx context.Context, inputPath string, opts *SummaryOptions) (*SummaryResult, error) {
+	paths, err := inputFiles(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := opts.Profile
+	if profile == nil {
+		profile = terf.InceptionProfile
+	}
+
+	stats := make(chan *Stats, len(paths))
+
+	fileErrors, err := processFiles(ctx, paths, opts.Threads, opts.KeepGoing, opts.Compress, opts.Progress, func(ctx context.Context, path string) (int, error) {
+		sum, err := fileSummary(ctx, path, opts, profile)
+		if err != nil {
+			return 0, err
+		}
+
+		stats <- sum
+		return sum.Total, nil
+	})
+	close(stats)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SummaryResult{
+		Stats:  NewStats(),
+		Errors: fileErrors,
+	}
+	for s := range stats {
+		res.Stats.Add(s)
+	}
+
+	return res, nil
+}
+
+func fileSummary(ctx context.Context, inputPath string, opts *SummaryOptions, profile *terf.Profile) (*Stats, error) {
+	stats := NewStats()
+
+	_, err := readFile(ctx, inputPath, opts.Compress, func(ex *protobuf.Example) error {
+		labelIDs := terf.ExampleFeatureInt64List(ex, profile.Label)
+		labelTexts := terf.ExampleFeatureBytesList(ex, profile.Text)
+		labelRaw := terf.ExampleFeatureInt64(ex, profile.LabelRaw)
+		format := string(terf.ExampleFeatureBytes(ex, profile.Format))
+		colorspace := string(terf.ExampleFeatureBytes(ex, profile.Colorspace))
+		sourceID := terf.ExampleFeatureInt64(ex, profile.Source)
+		channels := terf.ExampleFeatureInt64(ex, profile.Channels)
+
+		stats.Total++
+		if len(labelTexts) == 0 {
+			stats.LabelText[""]++
+		}
+		for _, text := range labelTexts {
+			stats.LabelText[string(text)]++
+		}
+		if len(labelIDs) == 0 {
+			stats.LabelID[0]++
+		}
+		for _, id := range labelIDs {
+			stats.LabelID[int(id)]++
+		}
+		for a := 0; a < len(labelTexts); a++ {
+			for b := a + 1; b < len(labelTexts); b++ {
+				pair := [2]string{string(labelTexts[a]), string(labelTexts[b])}
+				if pair[1] < pair[0] {
+					pair[0], pair[1] = pair[1], pair[0]
+				}
+				stats.CoOccurrence[pair]++
+			}
+		}
+		stats.LabelRaw[labelRaw]++
+		stats.Source[sourceID]++
+		stats.Format[format]++
+		stats.Colorspace[colorspace]++
+		if len(profile.Channels) > 0 {
+			stats.Channels[channels]++
+		}
+
+		objects, err := terf.ExampleObjects(ex, profile)
+		if err != nil {
+			return err
+		}
+		for _, b := range objects {
+			stats.ObjectID[b.LabelID]++
+			stats.ObjectText[b.LabelText]++
+		}
+
+		if opts.Pixels {
+			img := &terf.Image{Profile: profile}
+			if err := img.UnmarshalExample(ex); err != nil {
+				return err
+			}
+
+			im, err := img.Decode()
+			if err != nil {
+				return fmt.Errorf("Failed to decode image %d: %s", img.ID, err)
+			}
+
+			labels := []string{""}
+			if len(labelTexts) > 0 {
+				labels = make([]string, len(labelTexts))
+				for n, text := range labelTexts {
+					labels[n] = string(text)
+				}
+			}
+			stats.addPixels(im, opts.PixelChannels, sourceID, labels)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
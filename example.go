@@ -0,0 +1,41 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// ExampleMarshaler is the interface implemented by types that can marshal
+// themselves into a TensorFlow Example proto
+type ExampleMarshaler interface {
+	MarshalExample() (*protobuf.Example, error)
+}
+
+// ExamplesMarshaler is the interface implemented by types that marshal
+// themselves into several TensorFlow Example protos, for example the tiles of
+// an image
+type ExamplesMarshaler interface {
+	MarshalExamples() ([]*protobuf.Example, error)
+}
+
+// ExampleUnmarshaler is the interface implemented by types that can unmarshal
+// a TensorFlow Example proto into themselves
+type ExampleUnmarshaler interface {
+	UnmarshalExample(example *protobuf.Example) error
+}
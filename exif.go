@@ -0,0 +1,366 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+)
+
+const (
+	// EXIF tag for the image orientation
+	TagOrientation = 0x0112
+
+	// EXIF tags for the camera make and model and the date and time the
+	// file was changed
+	TagMake     = 0x010f
+	TagModel    = 0x0110
+	TagDateTime = 0x0132
+
+	// EXIF tags of the Exif sub-IFD for the capture settings
+	TagExposureTime     = 0x829a
+	TagFNumber          = 0x829d
+	TagISO              = 0x8827
+	TagDateTimeOriginal = 0x9003
+	TagFocalLength      = 0x920a
+
+	// EXIF tag for the offset of the Exif sub-IFD
+	tagExifIFD = 0x8769
+
+	// EXIF data types
+	exifByte      = 1
+	exifASCII     = 2
+	exifShort     = 3
+	exifLong      = 4
+	exifRational  = 5
+	exifUndefined = 7
+	exifSLong     = 9
+	exifSRational = 10
+)
+
+var (
+	// ErrNoEXIF is returned when the image data does not contain EXIF data
+	ErrNoEXIF = errors.New("No EXIF data found")
+
+	exifHeader = []byte("Exif\x00\x00")
+
+	exifTypeSize = map[uint16]uint32{
+		exifByte:      1,
+		exifASCII:     1,
+		exifShort:     2,
+		exifLong:      4,
+		exifRational:  8,
+		exifUndefined: 1,
+		exifSLong:     4,
+		exifSRational: 8,
+	}
+)
+
+// exifEntry is a single EXIF tag value
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// EXIF contains the tags parsed from the EXIF data of an image. Tags from the
+// primary image directory (IFD0) and the Exif sub-directory are included.
+type EXIF struct {
+	order binary.ByteOrder
+	tags  map[uint16]exifEntry
+}
+
+// DecodeEXIF parses the EXIF data embedded in raw image data. JPEG images
+// (APP1 segment) and TIFF images are supported. If no EXIF data is found
+// ErrNoEXIF is returned.
+func DecodeEXIF(raw []byte) (*EXIF, error) {
+	tiff := raw
+	if len(raw) > 2 && raw[0] == 0xff && raw[1] == 0xd8 {
+		tiff = jpegEXIF(raw)
+	}
+
+	if tiff == nil {
+		return nil, ErrNoEXIF
+	}
+
+	return parseTIFF(tiff)
+}
+
+// jpegEXIF returns the TIFF formatted EXIF data in the APP1 segment of the
+// JPEG image data raw or nil if not found
+func jpegEXIF(raw []byte) []byte {
+	pos := 2
+	for pos+4 <= len(raw) {
+		if raw[pos] != 0xff {
+			return nil
+		}
+
+		marker := raw[pos+1]
+		if marker == 0xd8 || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 || marker == 0xff {
+			pos++
+			continue
+		}
+
+		// Start of scan, no more metadata segments
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(raw[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(raw) {
+			return nil
+		}
+
+		data := raw[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(data, exifHeader) {
+			return data[len(exifHeader):]
+		}
+
+		pos = end
+	}
+
+	return nil
+}
+
+// parseTIFF parses the IFD0 and Exif sub-IFD tags from TIFF formatted data
+func parseTIFF(data []byte) (*EXIF, error) {
+	order := tiffOrder(data)
+	if order == nil {
+		return nil, ErrNoEXIF
+	}
+
+	e := &EXIF{
+		order: order,
+		tags:  make(map[uint16]exifEntry),
+	}
+
+	err := e.readIFD(data, order.Uint32(data[4:8]))
+	if err != nil {
+		return nil, err
+	}
+
+	if off, ok := e.Int(tagExifIFD); ok {
+		err := e.readIFD(data, uint32(off))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// readIFD reads the tags of the image file directory at offset
+func (e *EXIF) readIFD(data []byte, offset uint32) error {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return errors.New("Invalid EXIF directory offset")
+	}
+
+	n := uint32(e.order.Uint16(data[offset : offset+2]))
+	start := offset + 2
+	if uint64(start)+uint64(n)*12 > uint64(len(data)) {
+		return errors.New("Invalid EXIF directory size")
+	}
+
+	for i := uint32(0); i < n; i++ {
+		entry := data[start+i*12 : start+(i+1)*12]
+		tag := e.order.Uint16(entry[0:2])
+		typ := e.order.Uint16(entry[2:4])
+		count := e.order.Uint32(entry[4:8])
+
+		size, ok := exifTypeSize[typ]
+		if !ok {
+			continue
+		}
+
+		total := uint64(size) * uint64(count)
+		var value []byte
+		if total <= 4 {
+			value = entry[8 : 8+total]
+		} else {
+			off := uint64(e.order.Uint32(entry[8:12]))
+			if off+total > uint64(len(data)) {
+				continue
+			}
+			value = data[off : off+total]
+		}
+
+		e.tags[tag] = exifEntry{typ: typ, count: count, value: value}
+	}
+
+	return nil
+}
+
+// Int returns the first value of an integer tag
+func (e *EXIF) Int(tag uint16) (int, bool) {
+	entry, ok := e.tags[tag]
+	if !ok || entry.count == 0 {
+		return 0, false
+	}
+
+	switch entry.typ {
+	case exifByte, exifUndefined:
+		return int(entry.value[0]), true
+	case exifShort:
+		return int(e.order.Uint16(entry.value)), true
+	case exifLong:
+		return int(e.order.Uint32(entry.value)), true
+	case exifSLong:
+		return int(int32(e.order.Uint32(entry.value))), true
+	}
+
+	return 0, false
+}
+
+// String returns the value of an ASCII tag without trailing NUL bytes and
+// spaces
+func (e *EXIF) String(tag uint16) (string, bool) {
+	entry, ok := e.tags[tag]
+	if !ok || entry.typ != exifASCII {
+		return "", false
+	}
+
+	return strings.TrimRight(string(entry.value), "\x00 "), true
+}
+
+// Float returns the first value of a rational or integer tag
+func (e *EXIF) Float(tag uint16) (float64, bool) {
+	entry, ok := e.tags[tag]
+	if !ok || entry.count == 0 {
+		return 0, false
+	}
+
+	switch entry.typ {
+	case exifRational:
+		num, den := e.order.Uint32(entry.value[0:4]), e.order.Uint32(entry.value[4:8])
+		if den == 0 {
+			return 0, false
+		}
+		return float64(num) / float64(den), true
+	case exifSRational:
+		num, den := int32(e.order.Uint32(entry.value[0:4])), int32(e.order.Uint32(entry.value[4:8]))
+		if den == 0 {
+			return 0, false
+		}
+		return float64(num) / float64(den), true
+	}
+
+	v, ok := e.Int(tag)
+	return float64(v), ok
+}
+
+// Orientation returns the EXIF orientation (1-8). If the orientation tag is
+// missing or invalid 1 is returned.
+func (e *EXIF) Orientation() int {
+	o, ok := e.Int(TagOrientation)
+	if !ok || o < 1 || o > 8 {
+		return 1
+	}
+
+	return o
+}
+
+// exifOrientation returns the EXIF orientation of the raw image data or 1 if
+// there is no EXIF data
+func exifOrientation(raw []byte) int {
+	e, err := DecodeEXIF(raw)
+	if err != nil {
+		return 1
+	}
+
+	return e.Orientation()
+}
+
+// Orient returns a Transform that applies the EXIF orientation o to an image
+// so that it is displayed upright. Orientations 5-8 swap the width and height.
+func Orient(o int) Transform {
+	return TransformFunc(func(im image.Image) (image.Image, error) {
+		b := im.Bounds()
+		w, h := b.Dx(), b.Dy()
+
+		var fn func(x, y int) (int, int)
+		switch o {
+		case 2:
+			fn = func(x, y int) (int, int) { return w - 1 - x, y }
+		case 3:
+			fn = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+		case 4:
+			fn = func(x, y int) (int, int) { return x, h - 1 - y }
+		case 5:
+			fn = func(x, y int) (int, int) { return y, x }
+		case 6:
+			fn = func(x, y int) (int, int) { return y, h - 1 - x }
+		case 7:
+			fn = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+		case 8:
+			fn = func(x, y int) (int, int) { return w - 1 - y, x }
+		default:
+			return im, nil
+		}
+
+		dw, dh := w, h
+		if o >= 5 {
+			dw, dh = h, w
+		}
+
+		return remap(im, dw, dh, fn), nil
+	})
+}
+
+// remap returns a new w x h image where each pixel (x, y) is copied from the
+// pixel fn(x, y) of im. Coordinates are relative to the top left corner.
+// Pixels mapped outside im are left zero.
+func remap(im image.Image, w, h int, fn func(x, y int) (int, int)) image.Image {
+	b := im.Bounds()
+	outside := func(x, y int) bool {
+		return x < 0 || y < 0 || x >= b.Dx() || y >= b.Dy()
+	}
+
+	// Copy palette indexes so paletted masks keep their class values
+	if p, ok := im.(*image.Paletted); ok {
+		dst := image.NewPaletted(image.Rect(0, 0, w, h), p.Palette)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				sx, sy := fn(x, y)
+				if outside(sx, sy) {
+					continue
+				}
+				dst.SetColorIndex(x, y, p.ColorIndexAt(b.Min.X+sx, b.Min.Y+sy))
+			}
+		}
+
+		return dst
+	}
+
+	dst := newImageLike(im, image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := fn(x, y)
+			if outside(sx, sy) {
+				continue
+			}
+			dst.Set(x, y, im.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// tiffOrientation returns TIFF formatted EXIF data with a single orientation
+// tag
+func tiffOrientation(o int) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("II*\x00")
+	binary.Write(buf, binary.LittleEndian, uint32(8))
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	binary.Write(buf, binary.LittleEndian, uint16(TagOrientation))
+	binary.Write(buf, binary.LittleEndian, uint16(exifShort))
+	binary.Write(buf, binary.LittleEndian, uint32(1))
+	binary.Write(buf, binary.LittleEndian, uint16(o))
+	binary.Write(buf, binary.LittleEndian, uint16(0))
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+
+	return buf.Bytes()
+}
+
+// jpegWithEXIF returns a w x h JPEG image with an APP1 EXIF segment
+// containing tiff
+func jpegWithEXIF(t *testing.T, w, h int, tiff []byte) []byte {
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, w, h)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	app1 := append(append([]byte{}, exifHeader...), tiff...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(app1)+2))
+
+	out := append([]byte{}, raw[:2]...)
+	out = append(out, segment...)
+	out = append(out, app1...)
+	return append(out, raw[2:]...)
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	raw := jpegWithEXIF(t, 40, 20, tiffOrientation(6))
+
+	e, err := DecodeEXIF(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Orientation() != 6 {
+		t.Errorf("Incorrect orientation: got %d should be %d", e.Orientation(), 6)
+	}
+
+	im := &Image{}
+	if err := im.Read(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if im.Width != 20 || im.Height != 40 {
+		t.Errorf("Incorrect oriented size: got %dx%d should be 20x40", im.Width, im.Height)
+	}
+
+	if err := im.ToJPEG(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(im.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 20 || cfg.Height != 40 {
+		t.Errorf("Incorrect encoded size: got %dx%d should be 20x40", cfg.Width, cfg.Height)
+	}
+
+	if _, err := DecodeEXIF([]byte{0xff, 0xd8, 0xff, 0xd9}); err != ErrNoEXIF {
+		t.Errorf("Incorrect error: got %v should be %v", err, ErrNoEXIF)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2 image:
+	//  0 1 2
+	//  3 4 5
+	im := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range im.Pix {
+		im.Pix[i] = uint8(i)
+	}
+
+	tests := map[int][]uint8{
+		1: {0, 1, 2, 3, 4, 5},
+		2: {2, 1, 0, 5, 4, 3},
+		3: {5, 4, 3, 2, 1, 0},
+		4: {3, 4, 5, 0, 1, 2},
+		5: {0, 3, 1, 4, 2, 5},
+		6: {3, 0, 4, 1, 5, 2},
+		7: {5, 2, 4, 1, 3, 0},
+		8: {2, 5, 1, 4, 0, 3},
+	}
+
+	for o, expected := range tests {
+		out, err := Orient(o).Apply(im)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]uint8, 0)
+		b := out.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				got = append(got, color.GrayModel.Convert(out.At(x, y)).(color.Gray).Y)
+			}
+		}
+
+		if !bytes.Equal(got, expected) {
+			t.Errorf("Incorrect pixels for orientation %d: got %v should be %v", o, got, expected)
+		}
+	}
+}
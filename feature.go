@@ -0,0 +1,169 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// ParseFeature parses the string val into a TensorFlow Example proto feature
+// of the given kind. Valid kinds are int, float, and string.
+func ParseFeature(kind, val string) (*protobuf.Feature, error) {
+	switch kind {
+	case "int":
+		n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return Int64Feature(n), nil
+	case "float":
+		n, err := strconv.ParseFloat(strings.TrimSpace(val), 32)
+		if err != nil {
+			return nil, err
+		}
+		return FloatFeature(float32(n)), nil
+	case "string":
+		return BytesFeature([]byte(val)), nil
+	}
+
+	return nil, fmt.Errorf("Unknown feature type: %s", kind)
+}
+
+// FeatureKind returns the kind of the feature f as accepted by ParseFeature
+func FeatureKind(f *protobuf.Feature) string {
+	switch f.Kind.(type) {
+	case *protobuf.Feature_Int64List:
+		return "int"
+	case *protobuf.Feature_FloatList:
+		return "float"
+	}
+
+	return "string"
+}
+
+// FeatureString returns the string representation of the first value of
+// feature f. This is the inverse of ParseFeature.
+func FeatureString(f *protobuf.Feature) string {
+	switch val := f.Kind.(type) {
+	case *protobuf.Feature_Int64List:
+		if len(val.Int64List.Value) > 0 {
+			return strconv.FormatInt(val.Int64List.Value[0], 10)
+		}
+	case *protobuf.Feature_FloatList:
+		if len(val.FloatList.Value) > 0 {
+			return strconv.FormatFloat(float64(val.FloatList.Value[0]), 'g', -1, 32)
+		}
+	case *protobuf.Feature_BytesList:
+		if len(val.BytesList.Value) > 0 {
+			return string(val.BytesList.Value[0])
+		}
+	}
+
+	return ""
+}
+
+// Int64ListFeature is a helper function for encoding TensorFlow Example proto
+// Int64 features with multiple values
+func Int64ListFeature(vals []int64) *protobuf.Feature {
+	return &protobuf.Feature{
+		Kind: &protobuf.Feature_Int64List{
+			Int64List: &protobuf.Int64List{
+				Value: vals,
+			},
+		},
+	}
+}
+
+// FloatListFeature is a helper function for encoding TensorFlow Example proto
+// Float features with multiple values
+func FloatListFeature(vals []float32) *protobuf.Feature {
+	return &protobuf.Feature{
+		Kind: &protobuf.Feature_FloatList{
+			FloatList: &protobuf.FloatList{
+				Value: vals,
+			},
+		},
+	}
+}
+
+// BytesListFeature is a helper function for encoding TensorFlow Example proto
+// Bytes features with multiple values
+func BytesListFeature(vals [][]byte) *protobuf.Feature {
+	return &protobuf.Feature{
+		Kind: &protobuf.Feature_BytesList{
+			BytesList: &protobuf.BytesList{
+				Value: vals,
+			},
+		},
+	}
+}
+
+// ExampleFeatureInt64List is a helper function for decoding all values of a
+// proto Int64 feature from a TensorFlow Example. If key is not found it
+// returns nil
+func ExampleFeatureInt64List(example *protobuf.Example, key string) []int64 {
+	f, ok := example.Features.Feature[key]
+	if !ok {
+		return nil
+	}
+
+	val, ok := f.Kind.(*protobuf.Feature_Int64List)
+	if !ok {
+		return nil
+	}
+
+	return val.Int64List.Value
+}
+
+// ExampleFeatureFloatList is a helper function for decoding all values of a
+// proto Float feature from a TensorFlow Example. If key is not found it
+// returns nil
+func ExampleFeatureFloatList(example *protobuf.Example, key string) []float32 {
+	f, ok := example.Features.Feature[key]
+	if !ok {
+		return nil
+	}
+
+	val, ok := f.Kind.(*protobuf.Feature_FloatList)
+	if !ok {
+		return nil
+	}
+
+	return val.FloatList.Value
+}
+
+// ExampleFeatureBytesList is a helper function for decoding all values of a
+// proto Bytes feature from a TensorFlow Example. If key is not found it
+// returns nil
+func ExampleFeatureBytesList(example *protobuf.Example, key string) [][]byte {
+	f, ok := example.Features.Feature[key]
+	if !ok {
+		return nil
+	}
+
+	val, ok := f.Kind.(*protobuf.Feature_BytesList)
+	if !ok {
+		return nil
+	}
+
+	return val.BytesList.Value
+}
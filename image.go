@@ -19,24 +19,85 @@ package terf
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	protobuf "github.com/ubccr/terf/protobuf"
 
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 	_ "image/gif"
-	_ "image/png"
 )
 
+const (
+	// MetaPrefix is the Example feature key prefix for Extra image metadata
+	MetaPrefix = "image/meta/"
+)
+
+// CSVHeader is the default set of CSV columns for Image records
+var CSVHeader = []string{
+	"image_path",
+	"image_id",
+	"label_id",
+	"label_text",
+	"label_raw",
+	"source",
+}
+
+// Conversion is the target encoding when converting an Image
+type Conversion string
+
+const (
+	// ConvertNone keeps the original format. Images are only re-encoded when
+	// transforms are applied: JPEG images as JPEG in the same colorspace and
+	// all other formats as PNG
+	ConvertNone Conversion = "none"
+
+	// ConvertRGB converts images to JPEG in RGB colorspace
+	ConvertRGB Conversion = "rgb"
+
+	// ConvertGray converts images to JPEG in grayscale
+	ConvertGray Conversion = "gray"
+
+	// ConvertPNG converts images to lossless PNG keeping the channels and bit
+	// depth
+	ConvertPNG Conversion = "png"
+
+	// ConvertRaw stores images as decoded 8 bit pixels in RawFormat.
+	// Grayscale images have one channel and all other images three RGB
+	// channels
+	ConvertRaw Conversion = "raw"
+)
+
+// ParseConversion returns the Conversion with the given name. An empty name
+// returns ConvertNone.
+func ParseConversion(name string) (Conversion, error) {
+	switch c := Conversion(strings.ToLower(name)); c {
+	case "":
+		return ConvertNone, nil
+	case ConvertNone, ConvertRGB, ConvertGray, ConvertPNG, ConvertRaw:
+		return c, nil
+	case "jpeg":
+		return ConvertRGB, nil
+	}
+
+	return "", fmt.Errorf("Unknown conversion %s. Valid conversions are: none, rgb, gray, png, raw", name)
+}
+
 // Image is an Example image for training/validating in TensorFlow
 type Image struct {
 	// Unique ID for the image
@@ -57,6 +118,10 @@ type Image struct {
 	// The human-readable version of the normalized label
 	LabelText string
 
+	// Labels of a multi-label image. LabelID and LabelText are the first
+	// label. Empty for single label images
+	Labels []Label
+
 	// Integer ID for the source of the image. This is typically the
 	// organization or owner that created the image
 	SourceID int
@@ -64,14 +129,90 @@ type Image struct {
 	// Base filename of the original image
 	Filename string
 
-	// Image format (JPEG, PNG)
+	// Image format (jpeg, png, gif, tiff, bmp, webp, raw). See RawFormat
 	Format string
 
-	// Image colorpace (RGB, Gray)
+	// Image colorpace (Gray, GrayAlpha, RGB, RGBA, CMYK)
 	Colorspace string
 
+	// Number of channels produced when decoding the image
+	Channels int
+
+	// Bits per channel
+	BitDepth int
+
 	// Raw image data
 	Raw []byte
+
+	// Feature type used to store the pixels of images in RawFormat. Defaults
+	// to PixelBytes
+	PixelEncoding PixelEncoding
+
+	// Hex encoded SHA-256 of the raw image data as read by Read. This is a
+	// stable identity of the source image and is not changed when the image
+	// is converted
+	Key string
+
+	// Path of the source image file. Set by UnmarshalCSVHeader
+	SourcePath string
+
+	// Modification time of the source image file. Set by UnmarshalCSVHeader
+	ModTime time.Time
+
+	// Perceptual hashes keyed by hash function name, see ComputeHashes
+	Hashes map[string]uint64
+
+	// EXIF orientation (1-8) of the raw image data. Width and Height are the
+	// dimensions after applying the orientation. 0 or 1 means the image is
+	// stored upright.
+	Orientation int
+
+	// Page (starting at 0) of multi-page TIFF images selected by Read. Ignored
+	// for other formats
+	Page int
+
+	// Object bounding boxes
+	Objects []BBox
+
+	// Raw class segmentation mask. Each pixel is the class index of the
+	// corresponding image pixel
+	Mask []byte
+
+	// Format of the class segmentation mask (png)
+	MaskFormat string
+
+	// Raw PNG instance segmentation masks, one for each of Objects
+	InstanceMasks [][]byte
+
+	// Position of the image in its parent image if the image is a tile, see
+	// Tiles. Nil for whole images
+	Tile *TileInfo
+
+	// Source image and augmentation of augmented copies, see Augment. Nil for
+	// original images
+	Augmented *AugmentInfo
+
+	// Extra metadata features keyed by name. These are stored in the Example
+	// proto under the image/meta/ prefix
+	Extra map[string]*protobuf.Feature
+
+	// Profile used to map fields to Example proto feature keys. If nil,
+	// InceptionProfile is used
+	Profile *Profile
+
+	// Region of interest relative to the top left corner of the image. This is
+	// set from the optional crop_box CSV column and is applied by the build
+	// pipeline. An empty rectangle means no crop.
+	CropBox image.Rectangle
+
+	// JPEG quality (1-100) used when encoding. If 0, jpeg.DefaultQuality is
+	// used
+	JPEGQuality int
+
+	// If true, Convert leaves images that are already JPEG in the target
+	// colorspace untouched when no transforms are given, avoiding generation
+	// loss
+	SkipReencode bool
 }
 
 // Int64Feature is a helper function for encoding TensorFlow Example proto
@@ -120,7 +261,7 @@ func ExampleFeatureInt64(example *protobuf.Example, key string) int {
 	}
 
 	val, ok := f.Kind.(*protobuf.Feature_Int64List)
-	if !ok {
+	if !ok || len(val.Int64List.Value) == 0 {
 		return 0
 	}
 
@@ -137,7 +278,7 @@ func ExampleFeatureFloat(example *protobuf.Example, key string) float64 {
 	}
 
 	val, ok := f.Kind.(*protobuf.Feature_FloatList)
-	if !ok {
+	if !ok || len(val.FloatList.Value) == 0 {
 		return 0
 	}
 
@@ -154,7 +295,7 @@ func ExampleFeatureBytes(example *protobuf.Example, key string) []byte {
 	}
 
 	val, ok := f.Kind.(*protobuf.Feature_BytesList)
-	if !ok {
+	if !ok || len(val.BytesList.Value) == 0 {
 		return nil
 	}
 
@@ -189,52 +330,195 @@ func NewImage(r io.Reader, id, labelID, labelRaw int, labelText, filename string
 //
 //  image_path,image_id,label_id,label_text,label_raw,source
 func (i *Image) UnmarshalCSV(row []string) error {
-	if len(row) != 6 {
+	if len(row) != len(CSVHeader) {
+		return errors.New("Invalid CSV row format")
+	}
+
+	return i.UnmarshalCSVHeader(CSVHeader, row)
+}
+
+// UnmarshalCSVHeader decodes data from a single CSV record row into Image i
+// using header to map the columns. The image_path column is required, the
+// remaining columns of CSVHeader are optional. Multi-label images list several
+// label ids and label texts separated by LabelSeparator with optional
+// confidences in a label_confidence column, see ParseLabels. The optional
+// crop_box column sets CropBox in the form xmin,ymin,xmax,ymax and the
+// optional page column selects the Page of multi-page TIFF images. The
+// optional objects column sets Objects in pixel coordinates, see ParseBBoxes.
+// The optional mask_path column is the path to a PNG class segmentation mask
+// and instance_masks is a semicolon separated list of PNG instance mask paths,
+// one for each object. Mask dimensions must match the image. Any other column
+// is stored as an Extra feature. Extra column names can specify the feature
+// type using the form name:type where type is one of int, float, or string.
+// Columns without a type are stored as strings.
+func (i *Image) UnmarshalCSVHeader(header, row []string) error {
+	if len(row) != len(header) {
 		return errors.New("Invalid CSV row format")
 	}
 
-	iid, err := strconv.Atoi(row[1])
+	path := ""
+	labelIDs := ""
+	confidences := ""
+	objects := ""
+	maskPath := ""
+	instanceMasks := ""
+	for idx, col := range header {
+		val := row[idx]
+
+		var err error
+		switch col {
+		case "image_path":
+			path = val
+		case "image_id":
+			i.ID, err = strconv.Atoi(val)
+		case "label_id":
+			labelIDs = val
+		case "label_text":
+			i.LabelText = val
+		case "label_confidence":
+			confidences = val
+		case "label_raw":
+			i.LabelRaw, err = strconv.Atoi(val)
+		case "source":
+			i.SourceID, err = strconv.Atoi(val)
+		case "crop_box":
+			if len(val) > 0 {
+				i.CropBox, err = ParseRect(val)
+			}
+		case "page":
+			if len(val) > 0 {
+				i.Page, err = strconv.Atoi(val)
+			}
+		case "objects":
+			objects = val
+		case "mask_path":
+			maskPath = val
+		case "instance_masks":
+			instanceMasks = val
+		default:
+			err = i.setExtraCSV(col, val)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if strings.Contains(labelIDs, LabelSeparator) || len(confidences) > 0 {
+		labels, err := ParseLabels(labelIDs, i.LabelText, confidences)
+		if err != nil {
+			return err
+		}
+		i.setLabels(labels)
+	} else if len(labelIDs) > 0 {
+		var err error
+		i.LabelID, err = strconv.Atoi(labelIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(path) == 0 {
+		return errors.New("Missing image_path")
+	}
+
+	fh, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	lid, err := strconv.Atoi(row[2])
+	defer fh.Close()
+
+	err = i.Read(fh)
 	if err != nil {
 		return err
-
 	}
-	rid, err := strconv.Atoi(row[4])
+
+	i.Filename = filepath.Base(path)
+
+	i.SourcePath, err = filepath.Abs(path)
 	if err != nil {
 		return err
-
 	}
-	sid, err := strconv.Atoi(row[5])
+
+	info, err := fh.Stat()
 	if err != nil {
 		return err
+	}
+	i.ModTime = info.ModTime()
 
+	if len(objects) > 0 {
+		i.Objects, err = ParseBBoxes(objects, i.Width, i.Height)
+		if err != nil {
+			return err
+		}
 	}
 
-	i.ID = iid
-	i.LabelID = lid
-	i.LabelText = row[3]
-	i.LabelRaw = rid
-	i.SourceID = sid
+	if len(maskPath) > 0 || len(instanceMasks) > 0 {
+		err = i.setMasksCSV(maskPath, instanceMasks)
+		if err != nil {
+			return err
+		}
+	}
 
-	fh, err := os.Open(row[0])
-	if err != nil {
-		return err
+	return nil
+}
+
+// setExtraCSV parses the CSV value val for the typed column col and stores it
+// as an Extra feature. Empty values are skipped
+func (i *Image) setExtraCSV(col, val string) error {
+	name, kind := col, "string"
+	if idx := strings.LastIndex(col, ":"); idx > 0 {
+		name, kind = col[:idx], col[idx+1:]
 	}
-	defer fh.Close()
 
-	err = i.Read(fh)
+	if len(val) == 0 {
+		return nil
+	}
+
+	f, err := ParseFeature(kind, val)
 	if err != nil {
-		return err
+		return fmt.Errorf("Invalid value for column %s: %s", name, err)
 	}
 
-	i.Filename = filepath.Base(row[0])
+	if i.Extra == nil {
+		i.Extra = make(map[string]*protobuf.Feature)
+	}
+	i.Extra[name] = f
 
 	return nil
 }
 
+// clone returns a copy of Image i that does not share the Labels, Objects,
+// InstanceMasks, Hashes and Extra of i. Raw image and mask data is shared
+// since it is replaced rather than modified.
+func (i *Image) clone() *Image {
+	c := *i
+
+	if i.Labels != nil {
+		c.Labels = append([]Label{}, i.Labels...)
+	}
+	if i.Objects != nil {
+		c.Objects = append([]BBox{}, i.Objects...)
+	}
+	if i.InstanceMasks != nil {
+		c.InstanceMasks = append([][]byte{}, i.InstanceMasks...)
+	}
+	if i.Hashes != nil {
+		c.Hashes = make(map[string]uint64, len(i.Hashes))
+		for k, v := range i.Hashes {
+			c.Hashes[k] = v
+		}
+	}
+	if i.Extra != nil {
+		c.Extra = make(map[string]*protobuf.Feature, len(i.Extra))
+		for k, v := range i.Extra {
+			c.Extra[k] = v
+		}
+	}
+
+	return &c
+}
+
 // Name returns the generated base filename for the image: [id].[format]
 func (i *Image) Name() string {
 	var name string
@@ -249,6 +533,16 @@ func (i *Image) Name() string {
 		name = fmt.Sprintf("image.%s", strings.ToLower(i.Format))
 	}
 
+	if i.Augmented != nil {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s_aug%d%s", strings.TrimSuffix(name, ext), i.Augmented.Index, ext)
+	}
+
+	if i.Tile != nil {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s_%d_%d%s", strings.TrimSuffix(name, ext), i.Tile.X, i.Tile.Y, ext)
+	}
+
 	return name
 }
 
@@ -256,42 +550,166 @@ func (i *Image) Name() string {
 // UnmarshalCSV. The image_path will be generated based on the id of the image
 // and the provided baseDir.
 func (i *Image) MarshalCSV(baseDir string) []string {
-	return []string{
-		filepath.Join(baseDir, i.Name()),
-		strconv.Itoa(i.ID),
-		strconv.Itoa(i.LabelID),
-		i.LabelText,
-		strconv.Itoa(i.LabelRaw),
-		strconv.Itoa(i.SourceID),
+	return i.MarshalCSVHeader(CSVHeader, baseDir)
+}
+
+// MarshalCSVHeader encodes Image i into a CSV record with columns in the
+// order given by header. This is the inverse of UnmarshalCSVHeader. Extra
+// features missing from Image i are encoded as empty values.
+func (i *Image) MarshalCSVHeader(header []string, baseDir string) []string {
+	row := make([]string, len(header))
+	for idx, col := range header {
+		switch col {
+		case "image_path":
+			row[idx] = filepath.Join(baseDir, i.Name())
+		case "image_id":
+			row[idx] = strconv.Itoa(i.ID)
+		case "label_id":
+			row[idx] = strconv.Itoa(i.LabelID)
+			if len(i.Labels) > 0 {
+				row[idx], _, _ = FormatLabels(i.Labels)
+			}
+		case "label_text":
+			row[idx] = i.LabelText
+			if len(i.Labels) > 0 {
+				_, row[idx], _ = FormatLabels(i.Labels)
+			}
+		case "label_confidence":
+			_, _, row[idx] = FormatLabels(i.Labels)
+		case "label_raw":
+			row[idx] = strconv.Itoa(i.LabelRaw)
+		case "source":
+			row[idx] = strconv.Itoa(i.SourceID)
+		case "crop_box":
+			if !i.CropBox.Empty() {
+				row[idx] = FormatRect(i.CropBox)
+			}
+		case "objects":
+			row[idx] = FormatBBoxes(i.Objects, i.Width, i.Height)
+		case "mask_path":
+			row[idx], _ = i.maskPathsCSV(baseDir)
+		case "instance_masks":
+			_, row[idx] = i.maskPathsCSV(baseDir)
+		default:
+			name := col
+			if n := strings.LastIndex(col, ":"); n > 0 {
+				name = col[:n]
+			}
+			if f, ok := i.Extra[name]; ok {
+				row[idx] = FeatureString(f)
+			}
+		}
+	}
+
+	return row
+}
+
+// ExtraCSVHeader returns the sorted typed CSV column names for the Extra
+// features of Image i. See UnmarshalCSVHeader for the column name format
+func (i *Image) ExtraCSVHeader() []string {
+	cols := make([]string, 0, len(i.Extra))
+	for name, f := range i.Extra {
+		cols = append(cols, name+":"+FeatureKind(f))
 	}
+	sort.Strings(cols)
+
+	return cols
 }
 
 // UnmarshalExample decodes data from a TensorFlow example proto into Image i.
-// This is the inverse of MarshalExample.
+// This is the inverse of MarshalExample. Features are looked up using the
+// keys of the Image Profile and missing features are left as zero values. If
+// the Example does not include the width, height, format or colorspace they
+// are parsed from the raw image data.
 func (i *Image) UnmarshalExample(example *protobuf.Example) error {
+	p := i.profile()
 
-	// TODO make features optional? or configurable?
-	i.ID = ExampleFeatureInt64(example, "image/id")
-	i.Height = ExampleFeatureInt64(example, "image/height")
-	i.Width = ExampleFeatureInt64(example, "image/width")
-	i.LabelID = ExampleFeatureInt64(example, "image/class/label")
-	i.LabelRaw = ExampleFeatureInt64(example, "image/class/raw")
-	i.LabelText = string(ExampleFeatureBytes(example, "image/class/text"))
-	i.SourceID = ExampleFeatureInt64(example, "image/class/source")
-	i.Filename = string(ExampleFeatureBytes(example, "image/filename"))
-	i.Raw = ExampleFeatureBytes(example, "image/encoded")
-	i.Format = string(ExampleFeatureBytes(example, "image/format"))
+	if p.StringID {
+		id, err := strconv.Atoi(string(ExampleFeatureBytes(example, p.ID)))
+		if err == nil {
+			i.ID = id
+		}
+	} else {
+		i.ID = ExampleFeatureInt64(example, p.ID)
+	}
+
+	i.Height = ExampleFeatureInt64(example, p.Height)
+	i.Width = ExampleFeatureInt64(example, p.Width)
+	i.LabelID = ExampleFeatureInt64(example, p.Label)
+	i.LabelRaw = ExampleFeatureInt64(example, p.LabelRaw)
+	i.LabelText = string(ExampleFeatureBytes(example, p.Text))
+	i.SourceID = ExampleFeatureInt64(example, p.Source)
+	i.Filename = string(ExampleFeatureBytes(example, p.Filename))
+	i.Raw = ExampleFeatureBytes(example, p.Encoded)
+	i.Format = strings.ToLower(string(ExampleFeatureBytes(example, p.Format)))
+	i.Colorspace = string(ExampleFeatureBytes(example, p.Colorspace))
+	i.Channels = ExampleFeatureInt64(example, p.Channels)
+	if i.Format == RawFormat {
+		err := i.unmarshalPixels(example, p)
+		if err != nil {
+			return err
+		}
+	}
+	i.Key = string(ExampleFeatureBytes(example, p.Key))
+	i.SourcePath = string(ExampleFeatureBytes(example, p.SourcePath))
+	if mtime := ExampleFeatureInt64(example, p.ModTime); mtime != 0 {
+		i.ModTime = time.Unix(int64(mtime), 0)
+	}
+
+	if (i.Width == 0 || i.Height == 0 || len(i.Format) == 0 || len(i.Colorspace) == 0) && len(i.Raw) > 0 && i.Format != RawFormat {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(i.Raw))
+		if err == nil {
+			if i.Width == 0 || i.Height == 0 {
+				i.Width = cfg.Width
+				i.Height = cfg.Height
+			}
+			if len(i.Format) == 0 {
+				i.Format = format
+			}
+			if len(i.Colorspace) == 0 {
+				i.setColor(DetectColor(i.Raw, cfg.ColorModel))
+			}
+		}
+	}
+
+	err := i.unmarshalLabels(example, p)
+	if err != nil {
+		return err
+	}
+
+	i.Objects, err = ExampleObjects(example, p)
+	if err != nil {
+		return err
+	}
+	i.unmarshalMasks(example, p)
+	i.unmarshalHashes(example)
+	i.unmarshalTile(example)
+	i.unmarshalAugment(example)
+
+	if len(p.Meta) > 0 {
+		for key, f := range example.Features.Feature {
+			if !strings.HasPrefix(key, p.Meta) {
+				continue
+			}
+
+			if i.Extra == nil {
+				i.Extra = make(map[string]*protobuf.Feature)
+			}
+			i.Extra[strings.TrimPrefix(key, p.Meta)] = f
+		}
+	}
 
 	return nil
 }
 
-// MarshalExample converts the Image to a TensorFlow Example proto.
-// The Example proto schema is as follows:
+// MarshalExample converts the Image to a TensorFlow Example proto. The feature
+// keys are given by the Image Profile. The default InceptionProfile schema is
+// as follows:
 //
 //  image/height: integer, image height in pixels
 //  image/width: integer, image width in pixels
 //  image/colorspace: string, specifying the colorspace
-//  image/channels: integer, specifying the number of channels, always 3
+//  image/channels: integer, specifying the number of channels
 //  image/class/label: integer, specifying the index in a normalized classification layer
 //  image/class/raw: integer, specifying the index in the raw (original) classification layer
 //  image/class/source: integer, specifying the index of the source (creator of the image)
@@ -300,27 +718,111 @@ func (i *Image) UnmarshalExample(example *protobuf.Example) error {
 //  image/filename: string containing the basename of the image file
 //  image/id: integer, specifying the unique id for the image
 //  image/encoded: string, containing the raw encoded image
+//  image/meta/[name]: any Extra features, keeping their type
+//  image/key/sha256: string, SHA-256 of the source image data if set
+//  image/source/path: string, path of the source image file if set
+//  image/source/mtime: integer, modification time of the source image file in seconds since the epoch if set
+//  image/hash/[name]: integer, 64 bit perceptual hashes if computed
+//
+// Multi-label images store one value per label in image/class/label and
+// image/class/text and the label confidences in image/class/confidence if any
+// are not 1.
+//
+// If the image has Objects the bounding boxes are encoded as lists with one
+// value per box:
+//
+//  image/object/bbox/xmin: float, normalized left edge
+//  image/object/bbox/ymin: float, normalized top edge
+//  image/object/bbox/xmax: float, normalized right edge
+//  image/object/bbox/ymax: float, normalized bottom edge
+//  image/object/class/label: integer, object class
+//  image/object/class/text: string, human-readable object class
+//  image/object/difficult: integer, 1 if the object is difficult
+//  image/object/truncated: integer, 1 if the object is truncated
+//  image/object/mask: string, PNG encoded instance masks if set
+//
+// Class segmentation masks are stored as:
+//
+//  image/segmentation/class/encoded: string, containing the encoded mask
+//  image/segmentation/class/format: string, specifying the mask format
 func (i *Image) MarshalExample() (*protobuf.Example, error) {
+	p := i.profile()
+
+	features := make(map[string]*protobuf.Feature)
+	set := func(key string, f *protobuf.Feature) {
+		if len(key) > 0 {
+			features[key] = f
+		}
+	}
+
+	if len(p.Meta) > 0 {
+		for name, f := range i.Extra {
+			features[p.Meta+name] = f
+		}
+	}
+
+	if p.StringID {
+		set(p.ID, BytesFeature([]byte(strconv.Itoa(i.ID))))
+	} else {
+		set(p.ID, Int64Feature(int64(i.ID)))
+	}
+
+	set(p.Height, Int64Feature(int64(i.Height)))
+	set(p.Width, Int64Feature(int64(i.Width)))
+	set(p.Colorspace, BytesFeature([]byte(i.Colorspace)))
+	set(p.Channels, Int64Feature(int64(i.channels())))
+	set(p.Label, Int64Feature(int64(i.LabelID)))
+	set(p.LabelRaw, Int64Feature(int64(i.LabelRaw)))
+	set(p.Source, Int64Feature(int64(i.SourceID)))
+	set(p.Text, BytesFeature([]byte(i.LabelText)))
+	set(p.Format, BytesFeature([]byte(strings.ToUpper(i.Format))))
+	set(p.Filename, BytesFeature([]byte(i.Filename)))
+	set(p.Encoded, i.encodedFeature())
+	if i.Format == RawFormat {
+		set(p.Shape, Int64ListFeature([]int64{int64(i.Height), int64(i.Width), int64(i.channels())}))
+	}
+	if len(i.Key) > 0 {
+		set(p.Key, BytesFeature([]byte(i.Key)))
+	}
+	if len(i.SourcePath) > 0 {
+		set(p.SourcePath, BytesFeature([]byte(i.SourcePath)))
+	}
+	if !i.ModTime.IsZero() {
+		set(p.ModTime, Int64Feature(i.ModTime.Unix()))
+	}
+	i.marshalLabels(features, p)
+	marshalObjects(features, p, i.Objects)
+	i.marshalMasks(features, p)
+	i.marshalHashes(features)
+	i.marshalTile(features)
+	i.marshalAugment(features)
+
 	return &protobuf.Example{
 		Features: &protobuf.Features{
-			Feature: map[string]*protobuf.Feature{
-				"image/height":       Int64Feature(int64(i.Height)),
-				"image/width":        Int64Feature(int64(i.Width)),
-				"image/colorspace":   BytesFeature([]byte(i.Colorspace)),
-				"image/channels":     Int64Feature(3),
-				"image/class/label":  Int64Feature(int64(i.LabelID)),
-				"image/class/raw":    Int64Feature(int64(i.LabelRaw)),
-				"image/class/source": Int64Feature(int64(i.SourceID)),
-				"image/class/text":   BytesFeature([]byte(i.LabelText)),
-				"image/format":       BytesFeature([]byte(strings.ToUpper(i.Format))),
-				"image/filename":     BytesFeature([]byte(i.Filename)),
-				"image/id":           Int64Feature(int64(i.ID)),
-				"image/encoded":      BytesFeature(i.Raw),
-			},
+			Feature: features,
 		},
 	}, nil
 }
 
+// channels returns the number of channels of Image i. Defaults to 3 if
+// unknown
+func (i *Image) channels() int {
+	if i.Channels == 0 {
+		return 3
+	}
+
+	return i.Channels
+}
+
+// profile returns the Profile for Image i
+func (i *Image) profile() *Profile {
+	if i.Profile == nil {
+		return InceptionProfile
+	}
+
+	return i.Profile
+}
+
 // Write writes the raw Image data to w
 func (i *Image) Write(w io.Writer) error {
 	buf := bytes.NewReader(i.Raw)
@@ -330,7 +832,10 @@ func (i *Image) Write(w io.Writer) error {
 }
 
 // Reads raw image data from r, parses image config and sets Format,
-// Colorspace, Width and Height
+// Colorspace, Channels, BitDepth, Orientation, Width and Height. Width and Height are the
+// dimensions after applying the EXIF orientation. Key is set to the SHA-256 of
+// the data read. For TIFF images the header of the raw data is rewritten to
+// select Page.
 func (i *Image) Read(r io.Reader) error {
 	buf := new(bytes.Buffer)
 	_, err := buf.ReadFrom(r)
@@ -339,6 +844,16 @@ func (i *Image) Read(r io.Reader) error {
 	}
 	i.Raw = buf.Bytes()
 
+	sum := sha256.Sum256(i.Raw)
+	i.Key = hex.EncodeToString(sum[:])
+
+	if i.Page != 0 && tiffOrder(i.Raw) != nil {
+		i.Raw, err = tiffPage(i.Raw, i.Page)
+		if err != nil {
+			return err
+		}
+	}
+
 	cfg, format, err := image.DecodeConfig(bytes.NewReader(i.Raw))
 	if err != nil {
 		return err
@@ -347,44 +862,272 @@ func (i *Image) Read(r io.Reader) error {
 	i.Width = cfg.Width
 	i.Height = cfg.Height
 	i.Format = format
+	i.Orientation = 1
 
-	// TODO add better colorspace detection
-	switch cfg.ColorModel {
-	case color.YCbCrModel, color.RGBAModel, color.NRGBAModel:
-		i.Colorspace = "RGB"
-	case color.CMYKModel:
-		i.Colorspace = "CMYK"
-	case color.GrayModel, color.Gray16Model:
-		i.Colorspace = "Gray"
-	default:
-		i.Colorspace = "Unknown"
+	if format == "jpeg" || format == "tiff" {
+		i.Orientation = exifOrientation(i.Raw)
+		if i.Orientation >= 5 {
+			i.Width, i.Height = i.Height, i.Width
+		}
 	}
 
+	i.setColor(DetectColor(i.Raw, cfg.ColorModel))
+
 	return nil
 }
 
-// ToJPEG converts Image to JPEG format in RGB colorspace
-func (i *Image) ToJPEG() error {
-	orig, _, err := image.Decode(bytes.NewReader(i.Raw))
+// setColor sets the Colorspace, Channels and BitDepth of Image i
+func (i *Image) setColor(info ColorInfo) {
+	i.Colorspace = info.Colorspace
+	i.Channels = info.Channels
+	i.BitDepth = info.BitDepth
+}
+
+// Decode decodes the raw image data and applies the EXIF Orientation so the
+// decoded image is upright. Images in RawFormat are rebuilt from their pixels
+func (i *Image) Decode() (image.Image, error) {
+	if i.Format == RawFormat {
+		return rawImage(i.Raw, i.Width, i.Height, i.channels())
+	}
+
+	im, _, err := image.Decode(bytes.NewReader(i.Raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return Orient(i.Orientation).Apply(im)
+}
+
+// transform decodes Image i and applies the transforms in order. Geometric
+// transforms are also applied to the Objects and masks of i and the instance
+// masks of dropped objects are removed.
+func (i *Image) transform(transforms []Transform) (image.Image, error) {
+	im, err := i.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	var mask image.Image
+	var instances []image.Image
+	decoded := false
+
+	for _, t := range transforms {
+		b := im.Bounds()
+		im, err = t.Apply(im)
+		if err != nil {
+			return nil, err
+		}
+
+		g, ok := t.(Geometric)
+		if !ok {
+			continue
+		}
+
+		if !decoded {
+			instances, err = i.decodeMasks(b.Dx(), b.Dy())
+			if err != nil {
+				return nil, err
+			}
+			if len(i.Mask) > 0 {
+				mask, instances = instances[0], instances[1:]
+			}
+			decoded = true
+		}
+
+		if mask != nil {
+			mask, err = g.Mask(mask)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for n, m := range instances {
+			instances[n], err = g.Mask(m)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(i.Objects) == 0 {
+			continue
+		}
+
+		objects, kept, err := g.Boxes(i.Objects, b.Dx(), b.Dy())
+		if err != nil {
+			return nil, err
+		}
+		i.Objects = objects
+
+		if len(instances) > 0 {
+			keep := make([]image.Image, len(kept))
+			for n, k := range kept {
+				keep[n] = instances[k]
+			}
+			instances = keep
+		}
+	}
+
+	if mask != nil {
+		i.Mask, err = encodeMask(mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(i.InstanceMasks) > 0 && decoded {
+		i.InstanceMasks = make([][]byte, len(instances))
+		for n, m := range instances {
+			i.InstanceMasks[n], err = encodeMask(m)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return im, nil
+}
+
+// Transform decodes the image, applies the transforms in order and re-encodes
+// the image keeping the original format. See Convert with ConvertNone.
+func (i *Image) Transform(transforms ...Transform) error {
+	return i.Convert(ConvertNone, transforms...)
+}
+
+// ToJPEG converts Image to JPEG format in RGB colorspace applying any
+// transforms before encoding. See Convert with ConvertRGB.
+func (i *Image) ToJPEG(transforms ...Transform) error {
+	return i.Convert(ConvertRGB, transforms...)
+}
+
+// Convert decodes the image, applies the transforms in order and encodes the
+// image according to the conversion target c. Width, Height, Colorspace,
+// Channels and BitDepth are updated to match the converted image. JPEG images
+// are encoded with JPEGQuality at 8 bits per channel, PNG images keep 16 bit
+// depth.
+//
+// If SkipReencode is set and there are no transforms, upright JPEG images
+// already in the target colorspace are left unchanged to avoid generation
+// loss. With ConvertNone and no transforms the image is never re-encoded.
+func (i *Image) Convert(c Conversion, transforms ...Transform) error {
+	upright := i.Orientation <= 1
+
+	switch c {
+	case ConvertNone, "":
+		if len(transforms) == 0 && upright {
+			return nil
+		}
+	case ConvertRGB, ConvertGray:
+		colorspace := "RGB"
+		if c == ConvertGray {
+			colorspace = "Gray"
+		}
+
+		if i.SkipReencode && len(transforms) == 0 && upright && i.Format == "jpeg" && i.Colorspace == colorspace {
+			return nil
+		}
+	}
+
+	format, gray, err := i.encoding(c)
 	if err != nil {
 		return err
 	}
 
-	b := orig.Bounds()
-	im := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(im, im.Bounds(), orig, b.Min, draw.Src)
+	im, err := i.transform(transforms)
+	if err != nil {
+		return err
+	}
+
+	return i.encode(im, format, gray)
+}
 
+// encoding returns the output format for the conversion target c and whether
+// JPEG and raw images are encoded in grayscale. With ConvertNone JPEG and raw
+// images keep their format and colorspace and other formats are encoded as
+// PNG.
+func (i *Image) encoding(c Conversion) (string, bool, error) {
+	switch c {
+	case ConvertNone, "":
+		if i.Format == "jpeg" || i.Format == RawFormat {
+			return i.Format, i.Colorspace == "Gray", nil
+		}
+
+		return "png", false, nil
+	case ConvertRGB:
+		return "jpeg", false, nil
+	case ConvertGray:
+		return "jpeg", true, nil
+	case ConvertPNG:
+		return "png", false, nil
+	case ConvertRaw:
+		return RawFormat, i.Colorspace == "Gray", nil
+	}
+
+	return "", false, fmt.Errorf("Unknown conversion: %s", c)
+}
+
+// encode encodes the decoded image orig in format and replaces the raw image
+// data. If gray is set JPEG and raw images are encoded in grayscale.
+func (i *Image) encode(orig image.Image, format string, gray bool) error {
+	var err error
+	b := orig.Bounds()
 	buf := new(bytes.Buffer)
-	err = jpeg.Encode(buf, im, nil)
+
+	switch format {
+	case "jpeg":
+		var im draw.Image
+		if gray {
+			im = image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		} else {
+			im = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		}
+		draw.Draw(im, im.Bounds(), orig, b.Min, draw.Src)
+
+		quality := i.JPEGQuality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("Invalid JPEG quality %d, must be between 1 and 100", quality)
+		}
+
+		err = jpeg.Encode(buf, im, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(buf, orig)
+	case RawFormat:
+		i.Raw = rawPixels(orig, gray)
+		i.Format = RawFormat
+		i.Width = b.Dx()
+		i.Height = b.Dy()
+		i.Orientation = 1
+		i.Colorspace = "RGB"
+		i.Channels = 3
+		if gray {
+			i.Colorspace = "Gray"
+			i.Channels = 1
+		}
+		i.BitDepth = 8
+
+		return i.checkMasks()
+	default:
+		err = fmt.Errorf("Unsupported output format: %s", format)
+	}
 	if err != nil {
 		return err
 	}
 
 	i.Raw = buf.Bytes()
-	i.Format = "jpeg"
-	i.Colorspace = "RGB"
+	i.Format = format
+	i.Width = b.Dx()
+	i.Height = b.Dy()
+	i.Orientation = 1
 
-	return nil
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(i.Raw))
+	if err != nil {
+		return err
+	}
+	i.setColor(DetectColor(i.Raw, cfg.ColorModel))
+
+	return i.checkMasks()
 }
 
 // Save writes the Image to a file
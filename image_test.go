@@ -19,9 +19,19 @@ package terf
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRoundTrip(t *testing.T) {
@@ -111,6 +121,180 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestExtraFeatures(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.jpg")
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	header := append(append([]string{}, CSVHeader...), "well", "temperature:float", "imager:int")
+	row := []string{path, "1234", "1", "Crystal", "2", "104", "G6", "20.5", "7"}
+
+	im := &Image{}
+	err = im.UnmarshalCSVHeader(header, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	example, err := im.MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if well := string(ExampleFeatureBytes(example, "image/meta/well")); well != "G6" {
+		t.Errorf("Incorrect well: got %s should be %s", well, "G6")
+	}
+	if temp := ExampleFeatureFloat(example, "image/meta/temperature"); temp != 20.5 {
+		t.Errorf("Incorrect temperature: got %f should be %f", temp, 20.5)
+	}
+	if imager := ExampleFeatureInt64(example, "image/meta/imager"); imager != 7 {
+		t.Errorf("Incorrect imager: got %d should be %d", imager, 7)
+	}
+
+	img := &Image{}
+	err = img.UnmarshalExample(example)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cols := img.ExtraCSVHeader()
+	expected := []string{"imager:int", "temperature:float", "well:string"}
+	if strings.Join(cols, ",") != strings.Join(expected, ",") {
+		t.Errorf("Incorrect extra header: got %v should be %v", cols, expected)
+	}
+
+	out := img.MarshalCSVHeader(header, dir)
+	if strings.Join(out[1:], ",") != strings.Join(row[1:], ",") {
+		t.Errorf("Incorrect CSV row: got %v should be %v", out[1:], row[1:])
+	}
+}
+
+func TestJPEGQuality(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	sizes := make([]int, 0)
+	for _, q := range []int{10, 95} {
+		im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im.JPEGQuality = q
+		if err := im.ToJPEG(); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(im.Raw))
+	}
+
+	if sizes[0] >= sizes[1] {
+		t.Errorf("Expected smaller image at lower quality: got %d >= %d", sizes[0], sizes[1])
+	}
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im.SkipReencode = true
+	if err := im.ToJPEG(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(im.Raw, raw) {
+		t.Errorf("Expected raw image data to be unchanged")
+	}
+
+	im.JPEGQuality = 101
+	if err := im.ToJPEG(Resize(ResizeOptions{MaxDim: 10})); err == nil {
+		t.Errorf("Expected error for invalid quality")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.Convert(ConvertGray); err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "jpeg" || im.Colorspace != "Gray" || im.Channels != 1 {
+		t.Errorf("Invalid gray conversion: got %s %s %d", im.Format, im.Colorspace, im.Channels)
+	}
+
+	g := image.NewGray16(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		g.SetGray16(x, 1, color.Gray16{Y: uint16(x * 8000)})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err = NewImage(bytes.NewReader(buf.Bytes()), 1, 1, 1, "Crystal", "test.png", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.Convert(ConvertPNG, Crop(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "png" || im.Width != 4 || im.Colorspace != "Gray" || im.BitDepth != 16 {
+		t.Errorf("Invalid png conversion: got %s %d %s %d", im.Format, im.Width, im.Colorspace, im.BitDepth)
+	}
+
+	if _, err := ParseConversion("bogus"); err == nil {
+		t.Errorf("Expected error for unknown conversion")
+	}
+}
+
+func TestKey(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+	sum := sha256.Sum256(raw)
+	key := hex.EncodeToString(sum[:])
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Key != key {
+		t.Errorf("Invalid key: got %s should be %s", im.Key, key)
+	}
+
+	if err := im.Convert(ConvertGray); err != nil {
+		t.Fatal(err)
+	}
+	im.SourcePath = "/data/test.jpg"
+	im.ModTime = time.Unix(1500000000, 0)
+
+	ex, err := im.MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(ExampleFeatureBytes(ex, "image/key/sha256")); got != key {
+		t.Errorf("Invalid image/key/sha256: got %s should be %s", got, key)
+	}
+
+	im2 := &Image{}
+	if err := im2.UnmarshalExample(ex); err != nil {
+		t.Fatal(err)
+	}
+	if im2.Key != key || im2.SourcePath != im.SourcePath || !im2.ModTime.Equal(im.ModTime) {
+		t.Errorf("Invalid source info after round trip: got %s %s %s", im2.Key, im2.SourcePath, im2.ModTime)
+	}
+}
+
 const data = `
 /9j/4AAQSkZJRgABAQIAHAAcAAD/2wBDABALDA4MChAODQ4SERATGCgaGBYWGDEjJR0oOjM9PDkzODdA
 SFxOQERXRTc4UG1RV19iZ2hnPk1xeXBkeFxlZ2P/2wBDARESEhgVGC8aGi9jQjhCY2NjY2NjY2NjY2Nj
@@ -0,0 +1,196 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// LabelSeparator separates the values of multi-label CSV columns
+const LabelSeparator = "|"
+
+// Label is one of the labels of a multi-label image
+type Label struct {
+	// Integer ID for the normalized label (class)
+	ID int
+
+	// The human-readable version of the normalized label
+	Text string
+
+	// Confidence of the label. Defaults to 1
+	Confidence float64
+}
+
+// ParseLabels parses multi-label CSV values. ids, texts and confidences are
+// lists separated by LabelSeparator, for example "1|3", "Crystals|Precipitate"
+// and "0.9|0.4". texts and confidences are optional but must have one value
+// per id if given.
+func ParseLabels(ids, texts, confidences string) ([]Label, error) {
+	idList := strings.Split(ids, LabelSeparator)
+	labels := make([]Label, len(idList))
+	for n, val := range idList {
+		id, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid label id: %s", val)
+		}
+		labels[n] = Label{ID: id, Confidence: 1}
+	}
+
+	if len(texts) > 0 {
+		textList := strings.Split(texts, LabelSeparator)
+		if len(textList) != len(labels) {
+			return nil, errors.New("Number of label ids and label texts differ")
+		}
+		for n, text := range textList {
+			labels[n].Text = text
+		}
+	}
+
+	if len(confidences) > 0 {
+		confList := strings.Split(confidences, LabelSeparator)
+		if len(confList) != len(labels) {
+			return nil, errors.New("Number of label ids and label confidences differ")
+		}
+		for n, val := range confList {
+			c, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid label confidence: %s", val)
+			}
+			labels[n].Confidence = c
+		}
+	}
+
+	return labels, nil
+}
+
+// FormatLabels formats labels as the multi-label CSV values for label ids,
+// texts and confidences. This is the inverse of ParseLabels. Confidences are
+// empty if all labels have confidence 1.
+func FormatLabels(labels []Label) (string, string, string) {
+	ids := make([]string, len(labels))
+	texts := make([]string, len(labels))
+	confidences := make([]string, len(labels))
+	for n, l := range labels {
+		ids[n] = strconv.Itoa(l.ID)
+		texts[n] = l.Text
+		confidences[n] = strconv.FormatFloat(l.Confidence, 'g', -1, 32)
+	}
+
+	if !hasConfidence(labels) {
+		confidences = nil
+	}
+
+	return strings.Join(ids, LabelSeparator), strings.Join(texts, LabelSeparator), strings.Join(confidences, LabelSeparator)
+}
+
+// hasConfidence returns true if any label has a confidence other than 1
+func hasConfidence(labels []Label) bool {
+	for _, l := range labels {
+		if l.Confidence != 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setLabels sets Labels of Image i to labels. LabelID and LabelText are set
+// to the first label. A single label with confidence 1 is stored as a single
+// label image.
+func (i *Image) setLabels(labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	i.LabelID = labels[0].ID
+	i.LabelText = labels[0].Text
+	i.Labels = nil
+	if len(labels) > 1 || hasConfidence(labels) {
+		i.Labels = labels
+	}
+}
+
+// marshalLabels adds the multi-label features of Image i to features using
+// the keys of profile p
+func (i *Image) marshalLabels(features map[string]*protobuf.Feature, p *Profile) {
+	if len(i.Labels) == 0 {
+		return
+	}
+
+	ids := make([]int64, len(i.Labels))
+	texts := make([][]byte, len(i.Labels))
+	confidences := make([]float32, len(i.Labels))
+	for n, l := range i.Labels {
+		ids[n] = int64(l.ID)
+		texts[n] = []byte(l.Text)
+		confidences[n] = float32(l.Confidence)
+	}
+
+	if len(p.Label) > 0 {
+		features[p.Label] = Int64ListFeature(ids)
+	}
+	if len(p.Text) > 0 {
+		features[p.Text] = BytesListFeature(texts)
+	}
+	if len(p.Confidence) > 0 && hasConfidence(i.Labels) {
+		features[p.Confidence] = FloatListFeature(confidences)
+	}
+}
+
+// unmarshalLabels decodes the multi-label features of example into Image i
+// using the keys of profile p
+func (i *Image) unmarshalLabels(example *protobuf.Example, p *Profile) error {
+	ids := ExampleFeatureInt64List(example, p.Label)
+	texts := ExampleFeatureBytesList(example, p.Text)
+	confidences := ExampleFeatureFloatList(example, p.Confidence)
+
+	n := len(ids)
+	if len(texts) > n {
+		n = len(texts)
+	}
+	if n <= 1 && len(confidences) == 0 {
+		return nil
+	}
+
+	if (len(ids) > 0 && len(ids) != n) || (len(texts) > 0 && len(texts) != n) || (len(confidences) > 0 && len(confidences) != n) {
+		return errors.New("Invalid label features: value counts differ")
+	}
+
+	labels := make([]Label, n)
+	for idx := range labels {
+		labels[idx].Confidence = 1
+		if len(ids) > 0 {
+			labels[idx].ID = int(ids[idx])
+		}
+		if len(texts) > 0 {
+			labels[idx].Text = string(texts[idx])
+		}
+		if len(confidences) > 0 {
+			labels[idx].Confidence = float64(confidences[idx])
+		}
+	}
+
+	i.setLabels(labels)
+
+	return nil
+}
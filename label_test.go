@@ -0,0 +1,72 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("1|3", "Crystals|Precipitate", "0.5|1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(labels) != 2 || labels[1].ID != 3 || labels[1].Text != "Precipitate" || labels[0].Confidence != 0.5 {
+		t.Errorf("Invalid labels: got %+v", labels)
+	}
+
+	ids, texts, confidences := FormatLabels(labels)
+	if ids != "1|3" || texts != "Crystals|Precipitate" || confidences != "0.5|1" {
+		t.Errorf("Invalid format: got %s %s %s", ids, texts, confidences)
+	}
+
+	if _, err := ParseLabels("1|3", "Crystals", ""); err == nil {
+		t.Errorf("Expected error for mismatched label texts")
+	}
+}
+
+func TestMultiLabelRoundTrip(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystals", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im.setLabels([]Label{{ID: 1, Text: "Crystals", Confidence: 1}, {ID: 3, Text: "Precipitate", Confidence: 0.25}})
+
+	ex, err := im.MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ExampleFeatureInt64List(ex, "image/class/label"); len(got) != 2 || got[1] != 3 {
+		t.Errorf("Invalid image/class/label: got %v", got)
+	}
+
+	im2 := &Image{}
+	if err := im2.UnmarshalExample(ex); err != nil {
+		t.Fatal(err)
+	}
+
+	if im2.LabelID != 1 || im2.LabelText != "Crystals" || len(im2.Labels) != 2 || im2.Labels[1] != im.Labels[1] {
+		t.Errorf("Invalid labels after round trip: got %+v", im2.Labels)
+	}
+}
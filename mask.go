@@ -0,0 +1,181 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// readMask reads the segmentation mask image at path and returns the raw
+// data. Masks must be PNG images so class indexes are preserved exactly.
+func readMask(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid mask %s: %s", path, err)
+	}
+
+	if format != "png" {
+		return nil, fmt.Errorf("Invalid mask %s: masks must be PNG images", path)
+	}
+
+	return raw, nil
+}
+
+// checkMasks returns an error if the dimensions of any mask differ from the
+// dimensions of Image i
+func (i *Image) checkMasks() error {
+	masks := i.InstanceMasks
+	if len(i.Mask) > 0 {
+		masks = append([][]byte{i.Mask}, masks...)
+	}
+
+	for _, raw := range masks {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+
+		if cfg.Width != i.Width || cfg.Height != i.Height {
+			return fmt.Errorf("Mask size %dx%d does not match image size %dx%d", cfg.Width, cfg.Height, i.Width, i.Height)
+		}
+	}
+
+	if len(i.InstanceMasks) > 0 && len(i.InstanceMasks) != len(i.Objects) {
+		return errors.New("Number of instance masks does not match number of objects")
+	}
+
+	return nil
+}
+
+// MaskName returns the file name of the class segmentation mask of Image i.
+// If n >= 0 the name of the nth instance mask is returned.
+func (i *Image) MaskName(n int) string {
+	name := i.Name()
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	if n < 0 {
+		return fmt.Sprintf("%s_mask.%s", name, i.MaskFormat)
+	}
+
+	return fmt.Sprintf("%s_mask_%d.png", name, n)
+}
+
+// SaveMasks writes the segmentation masks of Image i to dir using MaskName
+func (i *Image) SaveMasks(dir string) error {
+	if len(i.Mask) > 0 {
+		err := ioutil.WriteFile(filepath.Join(dir, i.MaskName(-1)), i.Mask, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	for n, raw := range i.InstanceMasks {
+		err := ioutil.WriteFile(filepath.Join(dir, i.MaskName(n)), raw, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setMasksCSV reads the class segmentation mask at path and the semicolon
+// separated instance mask paths in instances into Image i
+func (i *Image) setMasksCSV(path, instances string) error {
+	if len(path) > 0 {
+		raw, err := readMask(path)
+		if err != nil {
+			return err
+		}
+		i.Mask = raw
+		i.MaskFormat = "png"
+	}
+
+	if len(instances) > 0 {
+		i.InstanceMasks = make([][]byte, 0)
+		for _, p := range strings.Split(instances, ";") {
+			raw, err := readMask(strings.TrimSpace(p))
+			if err != nil {
+				return err
+			}
+			i.InstanceMasks = append(i.InstanceMasks, raw)
+		}
+	}
+
+	return i.checkMasks()
+}
+
+// maskPathsCSV returns the class mask path and the semicolon separated
+// instance mask paths of Image i in baseDir. The mask data may have been
+// released, MaskFormat and the number of InstanceMasks determine which masks
+// exist.
+func (i *Image) maskPathsCSV(baseDir string) (string, string) {
+	path := ""
+	if len(i.MaskFormat) > 0 {
+		path = filepath.Join(baseDir, i.MaskName(-1))
+	}
+
+	instances := make([]string, len(i.InstanceMasks))
+	for n := range i.InstanceMasks {
+		instances[n] = filepath.Join(baseDir, i.MaskName(n))
+	}
+
+	return path, strings.Join(instances, ";")
+}
+
+// marshalMasks adds the segmentation mask features of Image i to features
+// using the keys of profile p
+func (i *Image) marshalMasks(features map[string]*protobuf.Feature, p *Profile) {
+	if len(i.Mask) > 0 && len(p.Segmentation) > 0 {
+		features[p.Segmentation+"/encoded"] = BytesFeature(i.Mask)
+		features[p.Segmentation+"/format"] = BytesFeature([]byte(i.MaskFormat))
+	}
+
+	if len(i.InstanceMasks) > 0 && len(p.Object) > 0 {
+		features[p.objectKey("mask")] = BytesListFeature(i.InstanceMasks)
+	}
+}
+
+// unmarshalMasks decodes the segmentation mask features of example into
+// Image i using the keys of profile p
+func (i *Image) unmarshalMasks(example *protobuf.Example, p *Profile) {
+	if len(p.Segmentation) > 0 {
+		i.Mask = ExampleFeatureBytes(example, p.Segmentation+"/encoded")
+		i.MaskFormat = strings.ToLower(string(ExampleFeatureBytes(example, p.Segmentation+"/format")))
+		if len(i.Mask) > 0 && len(i.MaskFormat) == 0 {
+			i.MaskFormat = "png"
+		}
+	}
+
+	if len(p.Object) > 0 {
+		i.InstanceMasks = ExampleFeatureBytesList(example, p.objectKey("mask"))
+	}
+}
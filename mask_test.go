@@ -0,0 +1,160 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePNG writes a w x h grayscale PNG image to path
+func writePNG(t *testing.T, path string, w, h int) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMasks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img := filepath.Join(dir, "img.png")
+	mask := filepath.Join(dir, "mask.png")
+	small := filepath.Join(dir, "small.png")
+	writePNG(t, img, 8, 6)
+	writePNG(t, mask, 8, 6)
+	writePNG(t, small, 4, 3)
+
+	header := []string{"image_path", "image_id", "objects", "mask_path", "instance_masks"}
+
+	im := &Image{}
+	err = im.UnmarshalCSVHeader(header, []string{img, "7", "0,0,4,3,1", mask, small})
+	if err == nil {
+		t.Errorf("Expected error for mask size mismatch")
+	}
+
+	im = &Image{}
+	err = im.UnmarshalCSVHeader(header, []string{img, "7", "0,0,4,3,1", mask, mask})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := im.MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format := string(ExampleFeatureBytes(ex, "image/segmentation/class/format")); format != "png" {
+		t.Errorf("Invalid mask format: got %s should be %s", format, "png")
+	}
+
+	im2 := &Image{}
+	if err := im2.UnmarshalExample(ex); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(im2.Mask, im.Mask) || len(im2.InstanceMasks) != 1 {
+		t.Errorf("Invalid masks after round trip")
+	}
+
+	if err := im2.SaveMasks(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"7_mask.png", "7_mask_0.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := im2.Transform(Crop(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range [][]byte{im2.Mask, im2.InstanceMasks[0]} {
+		cfg, err := png.DecodeConfig(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != 4 || cfg.Height != 4 {
+			t.Errorf("Incorrect mask size: got %dx%d should be 4x4", cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestTransformMasks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 100, 60))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Class 1 on the left half and class 2 on the right half with a palette
+	// that maps both classes to the same color
+	palette := color.Palette{color.Black, color.White, color.White}
+	mask := image.NewPaletted(image.Rect(0, 0, 100, 60), palette)
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 100; x++ {
+			mask.SetColorIndex(x, y, uint8(1+x/50))
+		}
+	}
+
+	im, err := NewImage(bytes.NewReader(buf.Bytes()), 7, 1, 1, "Crystal", "test.png", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im.Mask, err = encodeMask(mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im.MaskFormat = "png"
+
+	err = im.Convert(ConvertPNG, PadSquare(color.White), Resize(ResizeOptions{MaxDim: 50}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := png.Decode(bytes.NewReader(im.Mask))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := m.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Expected paletted mask")
+	}
+	if b := p.Bounds(); b.Dx() != 50 || b.Dy() != 50 {
+		t.Fatalf("Incorrect mask size: got %dx%d should be 50x50", b.Dx(), b.Dy())
+	}
+
+	// The 100x60 image is padded with 20 rows on the top and bottom
+	expected := map[image.Point]uint8{{0, 0}: 0, {10, 25}: 1, {40, 25}: 2, {40, 49}: 0}
+	for pt, idx := range expected {
+		if got := p.ColorIndexAt(pt.X, pt.Y); got != idx {
+			t.Errorf("Incorrect class at %v: got %d should be %d", pt, got, idx)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+const (
+	// EXIFPrefix is the Extra feature name prefix of captured EXIF fields.
+	// With the default profiles the camera model is stored in
+	// image/meta/exif/model
+	EXIFPrefix = "exif/"
+)
+
+// exifField is an EXIF value that can be stored as an Extra feature
+type exifField struct {
+	// Tags tried in order
+	Tags []uint16
+
+	// Returns the feature of the first tag found in e
+	value func(e *EXIF, tag uint16) (*protobuf.Feature, bool)
+}
+
+// exifFields are the EXIF fields that can be captured by name. capture_time
+// is the original date and time in EXIF format (YYYY:MM:DD HH:MM:SS) without
+// time zone and exposure_time is in seconds.
+var exifFields = map[string]*exifField{
+	"capture_time":  {Tags: []uint16{TagDateTimeOriginal, TagDateTime}, value: exifString},
+	"make":          {Tags: []uint16{TagMake}, value: exifString},
+	"model":         {Tags: []uint16{TagModel}, value: exifString},
+	"exposure_time": {Tags: []uint16{TagExposureTime}, value: exifFloat},
+	"f_number":      {Tags: []uint16{TagFNumber}, value: exifFloat},
+	"iso":           {Tags: []uint16{TagISO}, value: exifInt},
+	"focal_length":  {Tags: []uint16{TagFocalLength}, value: exifFloat},
+}
+
+func exifString(e *EXIF, tag uint16) (*protobuf.Feature, bool) {
+	s, ok := e.String(tag)
+	if !ok || len(s) == 0 {
+		return nil, false
+	}
+	return BytesFeature([]byte(s)), true
+}
+
+func exifFloat(e *EXIF, tag uint16) (*protobuf.Feature, bool) {
+	v, ok := e.Float(tag)
+	if !ok {
+		return nil, false
+	}
+	return FloatFeature(float32(v)), true
+}
+
+func exifInt(e *EXIF, tag uint16) (*protobuf.Feature, bool) {
+	v, ok := e.Int(tag)
+	if !ok {
+		return nil, false
+	}
+	return Int64Feature(int64(v)), true
+}
+
+// EXIFFieldNames returns the sorted names of the EXIF fields that can be
+// captured: capture_time, exposure_time, f_number, focal_length, iso, make and
+// model
+func EXIFFieldNames() []string {
+	names := make([]string, 0, len(exifFields))
+	for n := range exifFields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ParseEXIFFields parses a comma separated list of EXIF field names. "all"
+// selects all fields.
+func ParseEXIFFields(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "all" {
+		return EXIFFieldNames(), nil
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := exifFields[name]; !ok {
+			return nil, fmt.Errorf("Unknown EXIF field %s. Valid fields are: all, %s", name, strings.Join(EXIFFieldNames(), ", "))
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// CaptureEXIF stores the named EXIF fields (see EXIFFieldNames) of the raw image data as Extra
+// features named with EXIFPrefix. Fields missing from the image are skipped
+// and images without EXIF data are left unchanged.
+func (i *Image) CaptureEXIF(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	e, err := DecodeEXIF(i.Raw)
+	if err == ErrNoEXIF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		field, ok := exifFields[name]
+		if !ok {
+			return fmt.Errorf("Unknown EXIF field: %s", name)
+		}
+
+		for _, tag := range field.Tags {
+			f, ok := field.value(e, tag)
+			if !ok {
+				continue
+			}
+
+			if i.Extra == nil {
+				i.Extra = make(map[string]*protobuf.Feature)
+			}
+			i.Extra[EXIFPrefix+name] = f
+			break
+		}
+	}
+
+	return nil
+}
+
+// StripMetadata removes EXIF, XMP, ICC profiles, IPTC and comments from the
+// raw image data without re-encoding the pixels. For JPEG images the APP1 to
+// APP13 and APP15 segments and comments are removed, the JFIF (APP0) and
+// Adobe (APP14) segments needed to decode the image are kept. For PNG images
+// the eXIf, iTXt, tEXt, zTXt and iCCP chunks are removed and for WebP images
+// the EXIF, XMP and ICCP chunks. BMP and raw images have no metadata. Other
+// formats such as TIFF and GIF are re-encoded as lossless PNG since their
+// metadata can not be removed without rewriting the file. Orientation is
+// kept so the image is still rotated upright when converted.
+func (i *Image) StripMetadata() error {
+	var raw []byte
+	var err error
+
+	switch i.Format {
+	case "jpeg":
+		raw, err = stripJPEG(i.Raw)
+	case "png":
+		raw, err = stripPNG(i.Raw)
+	case "webp":
+		raw, err = stripWebP(i.Raw)
+	case "bmp", RawFormat:
+		return nil
+	default:
+		return i.Convert(ConvertPNG)
+	}
+	if err != nil {
+		return err
+	}
+
+	i.Raw = raw
+	return nil
+}
+
+// stripJPEG returns a copy of the JPEG data raw without metadata segments
+func stripJPEG(raw []byte) ([]byte, error) {
+	if len(raw) < 4 || raw[0] != 0xff || raw[1] != 0xd8 {
+		return nil, errors.New("Invalid JPEG data")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(raw)))
+	out.Write(raw[:2])
+
+	pos := 2
+	for pos+4 <= len(raw) {
+		if raw[pos] != 0xff {
+			return nil, errors.New("Invalid JPEG marker")
+		}
+
+		marker := raw[pos+1]
+		if marker == 0xff {
+			// Fill byte
+			pos++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8) {
+			out.Write(raw[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		// Start of scan or end of image, copy the rest of the data
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(raw[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(raw) {
+			return nil, errors.New("Invalid JPEG segment length")
+		}
+
+		strip := marker == 0xfe || (marker >= 0xe1 && marker <= 0xed) || marker == 0xef
+		if !strip {
+			out.Write(raw[pos:end])
+		}
+
+		pos = end
+	}
+
+	out.Write(raw[pos:])
+	return out.Bytes(), nil
+}
+
+// pngMetadata are the PNG chunk types removed by stripPNG
+var pngMetadata = map[string]bool{
+	"eXIf": true,
+	"iTXt": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iCCP": true,
+}
+
+// stripPNG returns a copy of the PNG data raw without metadata chunks
+func stripPNG(raw []byte) ([]byte, error) {
+	if len(raw) < 8 || string(raw[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("Invalid PNG data")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(raw)))
+	out.Write(raw[:8])
+
+	pos := 8
+	for pos < len(raw) {
+		if pos+12 > len(raw) {
+			return nil, errors.New("Invalid PNG chunk")
+		}
+
+		length := int(binary.BigEndian.Uint32(raw[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(raw) {
+			return nil, errors.New("Invalid PNG chunk length")
+		}
+
+		if !pngMetadata[string(raw[pos+4:pos+8])] {
+			out.Write(raw[pos:end])
+		}
+
+		pos = end
+	}
+
+	return out.Bytes(), nil
+}
+
+// webpMetadata are the WebP chunk types removed by stripWebP and their flags
+// in the VP8X chunk
+var webpMetadata = map[string]byte{
+	"ICCP": 0x20,
+	"EXIF": 0x08,
+	"XMP ": 0x04,
+}
+
+// stripWebP returns a copy of the WebP data raw without metadata chunks. The
+// metadata flags of the VP8X chunk are cleared.
+func stripWebP(raw []byte) ([]byte, error) {
+	if len(raw) < 12 || string(raw[:4]) != "RIFF" || string(raw[8:12]) != "WEBP" {
+		return nil, errors.New("Invalid WebP data")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(raw)))
+	out.Write(raw[:12])
+
+	pos := 12
+	for pos < len(raw) {
+		if pos+8 > len(raw) {
+			return nil, errors.New("Invalid WebP chunk")
+		}
+
+		fourcc := string(raw[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(raw[pos+4 : pos+8]))
+		end := pos + 8 + length + length%2
+		if length < 0 || end > len(raw) {
+			return nil, errors.New("Invalid WebP chunk length")
+		}
+
+		if _, ok := webpMetadata[fourcc]; !ok {
+			start := out.Len()
+			out.Write(raw[pos:end])
+
+			if fourcc == "VP8X" && length > 0 {
+				chunk := out.Bytes()[start:]
+				for _, flag := range webpMetadata {
+					chunk[8] &^= flag
+				}
+			}
+		}
+
+		pos = end
+	}
+
+	data := out.Bytes()
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+
+	return data, nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// tiffCamera returns TIFF formatted EXIF data with the camera model in IFD0
+// and the exposure time (1/250) and ISO (200) in the Exif sub-IFD
+func tiffCamera() []byte {
+	buf := new(bytes.Buffer)
+	le := binary.LittleEndian
+	entry := func(tag, typ uint16, count, value uint32) {
+		binary.Write(buf, le, tag)
+		binary.Write(buf, le, typ)
+		binary.Write(buf, le, count)
+		binary.Write(buf, le, value)
+	}
+
+	buf.WriteString("II*\x00")
+	binary.Write(buf, le, uint32(8))
+
+	// IFD0 at 8, model string at 38, Exif sub-IFD at 44, rational at 74
+	binary.Write(buf, le, uint16(2))
+	entry(TagModel, exifASCII, 6, 38)
+	entry(tagExifIFD, exifLong, 1, 44)
+	binary.Write(buf, le, uint32(0))
+	buf.WriteString("Nikon\x00")
+
+	binary.Write(buf, le, uint16(2))
+	entry(TagExposureTime, exifRational, 1, 74)
+	entry(TagISO, exifShort, 1, 200)
+	binary.Write(buf, le, uint32(0))
+	binary.Write(buf, le, []uint32{1, 250})
+
+	return buf.Bytes()
+}
+
+func TestCaptureEXIF(t *testing.T) {
+	raw := jpegWithEXIF(t, 8, 8, tiffCamera())
+
+	im := &Image{}
+	if err := im.Read(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ParseEXIFFields("model,exposure_time,iso,capture_time")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.CaptureEXIF(names...); err != nil {
+		t.Fatal(err)
+	}
+
+	if model := FeatureString(im.Extra["exif/model"]); model != "Nikon" {
+		t.Errorf("Incorrect model: got %s should be %s", model, "Nikon")
+	}
+	if exp := im.Extra["exif/exposure_time"].GetFloatList().Value[0]; math.Abs(float64(exp)-0.004) > 1e-6 {
+		t.Errorf("Incorrect exposure time: got %f should be %f", exp, 0.004)
+	}
+	if iso := im.Extra["exif/iso"].GetInt64List().Value[0]; iso != 200 {
+		t.Errorf("Incorrect ISO: got %d should be %d", iso, 200)
+	}
+	if _, ok := im.Extra["exif/capture_time"]; ok {
+		t.Errorf("Expected missing capture time to be skipped")
+	}
+
+	if _, err := ParseEXIFFields("model,gps"); err == nil {
+		t.Errorf("Expected error for unknown EXIF field")
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	raw := jpegWithEXIF(t, 8, 8, tiffCamera())
+
+	// Add a comment segment after the EXIF segment
+	comment := []byte{0xff, 0xfe, 0, 7, 's', 'e', 'c', 'r', 'e'}
+	raw = append(append(append([]byte{}, raw[:2]...), comment...), raw[2:]...)
+
+	im := &Image{}
+	if err := im.Read(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	orig, err := im.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.StripMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeEXIF(im.Raw); err != ErrNoEXIF {
+		t.Errorf("Expected EXIF to be removed: got %v", err)
+	}
+	if bytes.Contains(im.Raw, []byte("secre")) || bytes.Contains(im.Raw, []byte("Nikon")) {
+		t.Errorf("Expected metadata to be removed")
+	}
+
+	stripped, err := im.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(orig.(*image.Gray).Pix, stripped.(*image.Gray).Pix) {
+		t.Errorf("Pixels changed after stripping metadata")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+
+	// tEXt chunk after the 25 byte IHDR chunk
+	data := []byte("Author\x00someone")
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], "tEXt")
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	raw := append(append(append([]byte{}, clean[:33]...), chunk...), clean[33:]...)
+
+	im := &Image{}
+	if err := im.Read(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.StripMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(im.Raw, clean) {
+		t.Errorf("Incorrect stripped PNG data")
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	le := binary.LittleEndian
+	chunk := func(fourcc string, data []byte) []byte {
+		c := append([]byte(fourcc), 0, 0, 0, 0)
+		le.PutUint32(c[4:8], uint32(len(data)))
+		c = append(c, data...)
+		if len(data)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+
+	// VP8X with the ICC, EXIF and XMP flags followed by the metadata and
+	// image chunks
+	vp8x := chunk("VP8X", []byte{0x2c, 0, 0, 0, 3, 0, 0, 3, 0, 0})
+	body := append([]byte("WEBP"), vp8x...)
+	body = append(body, chunk("ICCP", []byte("icc"))...)
+	body = append(body, chunk("EXIF", tiffCamera())...)
+	body = append(body, chunk("VP8L", []byte("pixels"))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	raw := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	le.PutUint32(raw[4:8], uint32(len(body)))
+
+	stripped, err := stripWebP(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append([]byte("RIFF\x00\x00\x00\x00WEBP"), chunk("VP8X", []byte{0, 0, 0, 0, 3, 0, 0, 3, 0, 0})...)
+	expected = append(expected, chunk("VP8L", []byte("pixels"))...)
+	le.PutUint32(expected[4:8], uint32(len(expected)-8))
+
+	if !bytes.Equal(stripped, expected) {
+		t.Errorf("Incorrect stripped WebP data: got %q should be %q", stripped, expected)
+	}
+}
+
+func TestStripTIFF(t *testing.T) {
+	im := &Image{}
+	if err := im.Read(bytes.NewReader(multiTIFF(image.Pt(4, 3)))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.StripMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	if im.Format != "png" || im.Width != 4 || im.Height != 3 {
+		t.Errorf("Expected TIFF to be re-encoded as PNG: got %s %dx%d", im.Format, im.Width, im.Height)
+	}
+}
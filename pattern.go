@@ -0,0 +1,131 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+// FilenamePattern extracts Extra features from the file names of images with
+// the named groups of a regular expression. For example the pattern
+//
+//  ^(?P<plate>[0-9a-f]+)_(?P<well>[A-H][0-9]+)_(?P<profile>[^_]+)_(?P<imaging>[0-9]+)\.
+//
+// stores image/meta/plate, image/meta/well, image/meta/profile and
+// image/meta/imaging for the file name 03c3_G6_ImagerDefaults_6.jpg.
+type FilenamePattern struct {
+	Regexp *regexp.Regexp
+
+	// Feature type (int, float or string) by group name. Groups without a
+	// type are stored as strings
+	Types map[string]string
+
+	// Match the absolute source path instead of the base file name
+	SourcePath bool
+}
+
+// ParseFilenamePattern returns the FilenamePattern for the regular expression
+// expr. types is a comma separated list of group=type pairs, for example
+// "imaging=int". expr must have at least one named group.
+func ParseFilenamePattern(expr, types string, sourcePath bool) (*FilenamePattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filename pattern: %s", err)
+	}
+
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if len(name) > 0 {
+			groups[name] = true
+		}
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("Filename pattern must have at least one named group")
+	}
+
+	p := &FilenamePattern{Regexp: re, Types: make(map[string]string), SourcePath: sourcePath}
+	for _, pair := range strings.Split(types, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid filename pattern type %s, should be group=type", pair)
+		}
+
+		name, kind := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !groups[name] {
+			return nil, fmt.Errorf("Unknown filename pattern group: %s", name)
+		}
+		if kind != "int" && kind != "float" && kind != "string" {
+			return nil, fmt.Errorf("Unknown feature type for group %s: %s", name, kind)
+		}
+		p.Types[name] = kind
+	}
+
+	return p, nil
+}
+
+// ApplyPattern stores the named groups of FilenamePattern p matched against
+// the Filename (or SourcePath) of Image i as Extra features. Groups that do
+// not participate in the match are skipped and existing Extra features, for
+// example from CSV columns, take precedence. An error is returned if the name
+// does not match.
+func (i *Image) ApplyPattern(p *FilenamePattern) error {
+	name := i.Filename
+	if p.SourcePath {
+		name = i.SourcePath
+	}
+
+	match := p.Regexp.FindStringSubmatchIndex(name)
+	if match == nil {
+		return fmt.Errorf("File name %s does not match pattern %s", name, p.Regexp)
+	}
+
+	for n, group := range p.Regexp.SubexpNames() {
+		if len(group) == 0 || match[2*n] < 0 {
+			continue
+		}
+		if _, ok := i.Extra[group]; ok {
+			continue
+		}
+
+		kind, ok := p.Types[group]
+		if !ok {
+			kind = "string"
+		}
+
+		f, err := ParseFeature(kind, name[match[2*n]:match[2*n+1]])
+		if err != nil {
+			return fmt.Errorf("Invalid value for filename pattern group %s: %s", group, err)
+		}
+
+		if i.Extra == nil {
+			i.Extra = make(map[string]*protobuf.Feature)
+		}
+		i.Extra[group] = f
+	}
+
+	return nil
+}
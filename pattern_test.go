@@ -0,0 +1,86 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"testing"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+func TestFilenamePattern(t *testing.T) {
+	p, err := ParseFilenamePattern(`^(?P<plate>[0-9a-f]+)_(?P<well>[A-H][0-9]+)_(?P<profile>[^_]+)_(?P<imaging>[0-9]+)\.`, "imaging=int", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im := &Image{Filename: "03c3_G6_ImagerDefaults_6.jpg"}
+	err = im.ApplyPattern(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"plate": "03c3", "well": "G6", "profile": "ImagerDefaults"} {
+		if got := FeatureString(im.Extra[name]); got != want {
+			t.Errorf("Invalid %s: got %s should be %s", name, got, want)
+		}
+	}
+	if n := im.Extra["imaging"].GetInt64List().Value[0]; n != 6 {
+		t.Errorf("Invalid imaging: got %d should be 6", n)
+	}
+
+	// CSV columns take precedence
+	im = &Image{Filename: "03c3_G6_ImagerDefaults_6.jpg", Extra: map[string]*protobuf.Feature{"well": BytesFeature([]byte("A1"))}}
+	err = im.ApplyPattern(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if well := FeatureString(im.Extra["well"]); well != "A1" {
+		t.Errorf("CSV column overwritten: got %s should be A1", well)
+	}
+
+	err = (&Image{Filename: "other.jpg"}).ApplyPattern(p)
+	if err == nil {
+		t.Error("Non-matching file name should fail")
+	}
+
+	p, err = ParseFilenamePattern(`/(?P<plate>[0-9a-f]+)/[^/]+$`, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im = &Image{Filename: "x.jpg", SourcePath: "/data/03c3/x.jpg"}
+	err = im.ApplyPattern(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plate := FeatureString(im.Extra["plate"]); plate != "03c3" {
+		t.Errorf("Invalid source path plate: got %s should be 03c3", plate)
+	}
+
+	for _, tc := range []struct{ expr, types string }{
+		{`[0-9]+`, ""},
+		{`(?P<n>[0-9]+`, ""},
+		{`(?P<n>[0-9]+)`, "n=bool"},
+		{`(?P<n>[0-9]+)`, "m=int"},
+		{`(?P<n>[0-9]+)`, "n"},
+	} {
+		if _, err := ParseFilenamePattern(tc.expr, tc.types, false); err == nil {
+			t.Errorf("Invalid pattern %s %s should fail", tc.expr, tc.types)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// HashPrefix is the Example feature key prefix for perceptual hashes.
+	// Hashes are stored as int64 features named after the hash function, for
+	// example image/hash/dhash
+	HashPrefix = "image/hash/"
+)
+
+// HashFunc computes a 64 bit perceptual hash of an image. Similar images have
+// hashes with a small Hamming distance.
+type HashFunc func(im image.Image) uint64
+
+// HashFuncs are the perceptual hash functions available by name
+var HashFuncs = map[string]HashFunc{
+	"dhash": DHash,
+	"phash": PHash,
+}
+
+// ParseHash returns the perceptual hash function with the given name. An
+// empty name returns DHash.
+func ParseHash(name string) (HashFunc, error) {
+	if len(name) == 0 {
+		return DHash, nil
+	}
+
+	f, ok := HashFuncs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown hash %s. Valid hashes are: %s", name, strings.Join(HashNames(), ", "))
+	}
+
+	return f, nil
+}
+
+// HashNames returns the sorted names of HashFuncs
+func HashNames() []string {
+	names := make([]string, 0, len(HashFuncs))
+	for n := range HashFuncs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// HammingDistance returns the number of bits that differ between hashes a and
+// b
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash returns the hash h as a 16 digit hex string
+func FormatHash(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// ParseHashString parses a hash formatted by FormatHash
+func ParseHashString(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// grayScale returns the luminance of im scaled to w x h pixels
+func grayScale(im image.Image, w, h int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), im, im.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+// DHash computes the difference hash of im. The image is reduced to 9x8
+// grayscale pixels and each bit is set if a pixel is brighter than its right
+// neighbour.
+func DHash(im image.Image) uint64 {
+	g := grayScale(im, 9, 8)
+
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g.GrayAt(x, y).Y > g.GrayAt(x+1, y).Y {
+				h |= 1
+			}
+		}
+	}
+
+	return h
+}
+
+// PHash computes the DCT based perceptual hash of im. The image is reduced to
+// 32x32 grayscale pixels and each bit of the 8x8 lowest frequencies of the
+// discrete cosine transform is set if the coefficient is above the median,
+// excluding the DC term.
+func PHash(im image.Image) uint64 {
+	const size = 32
+	g := grayScale(im, size, size)
+
+	// Separable 2D DCT-II, only the 8 lowest frequencies are needed
+	cos := make([][]float64, 8)
+	for u := range cos {
+		cos[u] = make([]float64, size)
+		for x := 0; x < size; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+
+	rows := make([][]float64, size)
+	for y := 0; y < size; y++ {
+		rows[y] = make([]float64, 8)
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < size; x++ {
+				sum += float64(g.GrayAt(x, y).Y) * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	sorted := append([]float64{}, coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+
+	return h
+}
+
+// ComputeHashes decodes the image and sets Hashes for each of the named
+// perceptual hash functions
+func (i *Image) ComputeHashes(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	im, err := i.Decode()
+	if err != nil {
+		return err
+	}
+
+	if i.Hashes == nil {
+		i.Hashes = make(map[string]uint64)
+	}
+
+	for _, name := range names {
+		fn, err := ParseHash(name)
+		if err != nil {
+			return err
+		}
+		i.Hashes[strings.ToLower(name)] = fn(im)
+	}
+
+	return nil
+}
+
+// marshalHashes adds the perceptual hash features of Image i to features
+func (i *Image) marshalHashes(features map[string]*protobuf.Feature) {
+	for name, h := range i.Hashes {
+		features[HashPrefix+name] = Int64Feature(int64(h))
+	}
+}
+
+// unmarshalHashes decodes the perceptual hash features of example into Image i
+func (i *Image) unmarshalHashes(example *protobuf.Example) {
+	for key, f := range example.Features.Feature {
+		if !strings.HasPrefix(key, HashPrefix) {
+			continue
+		}
+
+		val, ok := f.Kind.(*protobuf.Feature_Int64List)
+		if !ok || len(val.Int64List.Value) == 0 {
+			continue
+		}
+
+		if i.Hashes == nil {
+			i.Hashes = make(map[string]uint64)
+		}
+		i.Hashes[strings.TrimPrefix(key, HashPrefix)] = uint64(val.Int64List.Value[0])
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// noiseImage returns a w x h grayscale image of random 4x4 blocks offset by
+// delta
+func noiseImage(seed int64, w, h int, delta int) *image.Gray {
+	rnd := rand.New(rand.NewSource(seed))
+	im := image.NewGray(image.Rect(0, 0, w, h))
+	for by := 0; by < h; by += 4 {
+		for bx := 0; bx < w; bx += 4 {
+			v := uint8(20 + rnd.Intn(180) + delta)
+			for y := by; y < by+4 && y < h; y++ {
+				for x := bx; x < bx+4 && x < w; x++ {
+					im.SetGray(x, y, color.Gray{Y: v})
+				}
+			}
+		}
+	}
+
+	return im
+}
+
+func TestPerceptualHash(t *testing.T) {
+	a := noiseImage(1, 64, 48, 0)
+	b := noiseImage(1, 64, 48, 10)
+	c := noiseImage(2, 64, 48, 0)
+
+	for _, name := range HashNames() {
+		fn, err := ParseHash(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if d := HammingDistance(fn(a), fn(b)); d > 4 {
+			t.Errorf("%s: Expected similar images to have close hashes: got distance %d", name, d)
+		}
+		if d := HammingDistance(fn(a), fn(c)); d < 10 {
+			t.Errorf("%s: Expected different images to have distant hashes: got distance %d", name, d)
+		}
+	}
+
+	if _, err := ParseHash("bogus"); err == nil {
+		t.Errorf("Expected error for unknown hash")
+	}
+
+	h, err := ParseHashString(FormatHash(0xdeadbeef))
+	if err != nil || h != 0xdeadbeef {
+		t.Errorf("Invalid hash string round trip: got %x", h)
+	}
+}
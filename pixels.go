@@ -0,0 +1,192 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+const (
+	// RawFormat is the Format of images stored as decoded 8 bit pixels in
+	// row-major height x width x channels order
+	RawFormat = "raw"
+)
+
+// PixelEncoding is the Example feature type used to store the pixels of
+// images in RawFormat
+type PixelEncoding string
+
+const (
+	// PixelBytes stores the pixels as a single bytes feature. This is the
+	// most compact layout and is decoded with tf.io.decode_raw
+	PixelBytes PixelEncoding = "bytes"
+
+	// PixelInt64 stores the pixels as an int64 list feature
+	PixelInt64 PixelEncoding = "int64"
+
+	// PixelFloat stores the pixels as a float list feature with values 0-255
+	PixelFloat PixelEncoding = "float"
+)
+
+// ParsePixelEncoding returns the PixelEncoding with the given name. An empty
+// name returns PixelBytes.
+func ParsePixelEncoding(name string) (PixelEncoding, error) {
+	switch e := PixelEncoding(strings.ToLower(name)); e {
+	case "":
+		return PixelBytes, nil
+	case PixelBytes, PixelInt64, PixelFloat:
+		return e, nil
+	}
+
+	return "", fmt.Errorf("Unknown pixel encoding %s. Valid encodings are: bytes, int64, float", name)
+}
+
+// rawPixels returns the 8 bit pixels of im in row-major order with one
+// channel if gray is set and three RGB channels otherwise
+func rawPixels(im image.Image, gray bool) []byte {
+	b := im.Bounds()
+	channels := 3
+	if gray {
+		channels = 1
+	}
+
+	raw := make([]byte, 0, b.Dx()*b.Dy()*channels)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := im.At(x, y)
+			if gray {
+				raw = append(raw, color.GrayModel.Convert(c).(color.Gray).Y)
+				continue
+			}
+
+			rgb := color.NRGBAModel.Convert(c).(color.NRGBA)
+			raw = append(raw, rgb.R, rgb.G, rgb.B)
+		}
+	}
+
+	return raw
+}
+
+// rawImage returns the image of the w x h pixels in raw with 1 (gray), 3
+// (RGB) or 4 (RGBA) channels
+func rawImage(raw []byte, w, h, channels int) (image.Image, error) {
+	if len(raw) != w*h*channels {
+		return nil, fmt.Errorf("Invalid pixel data: got %d bytes for shape %dx%dx%d", len(raw), h, w, channels)
+	}
+
+	r := image.Rect(0, 0, w, h)
+	switch channels {
+	case 1:
+		return &image.Gray{Pix: raw, Stride: w, Rect: r}, nil
+	case 3:
+		im := image.NewNRGBA(r)
+		for n := 0; n < w*h; n++ {
+			copy(im.Pix[4*n:4*n+3], raw[3*n:3*n+3])
+			im.Pix[4*n+3] = 0xff
+		}
+		return im, nil
+	case 4:
+		return &image.NRGBA{Pix: raw, Stride: 4 * w, Rect: r}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported number of channels for pixel data: %d", channels)
+}
+
+// encodedFeature returns the Example feature for the raw data of Image i.
+// Images in RawFormat use the feature type of PixelEncoding.
+func (i *Image) encodedFeature() *protobuf.Feature {
+	if i.Format != RawFormat {
+		return BytesFeature(i.Raw)
+	}
+
+	switch i.PixelEncoding {
+	case PixelInt64:
+		vals := make([]int64, len(i.Raw))
+		for n, v := range i.Raw {
+			vals[n] = int64(v)
+		}
+		return Int64ListFeature(vals)
+	case PixelFloat:
+		vals := make([]float32, len(i.Raw))
+		for n, v := range i.Raw {
+			vals[n] = float32(v)
+		}
+		return FloatListFeature(vals)
+	}
+
+	return BytesFeature(i.Raw)
+}
+
+// unmarshalPixels decodes the pixels and shape of an image in RawFormat from
+// example into Image i using the keys of profile p
+func (i *Image) unmarshalPixels(example *protobuf.Example, p *Profile) error {
+	f := example.Features.Feature[p.Encoded]
+	kind := "string"
+	if f != nil {
+		kind = FeatureKind(f)
+	}
+
+	switch kind {
+	case "int":
+		vals := f.GetInt64List().Value
+		i.Raw = make([]byte, len(vals))
+		for n, v := range vals {
+			i.Raw[n] = byte(v)
+		}
+		i.PixelEncoding = PixelInt64
+	case "float":
+		vals := f.GetFloatList().Value
+		i.Raw = make([]byte, len(vals))
+		for n, v := range vals {
+			i.Raw[n] = byte(math.Max(0, math.Min(255, math.Round(float64(v)))))
+		}
+		i.PixelEncoding = PixelFloat
+	default:
+		i.PixelEncoding = PixelBytes
+	}
+
+	if len(p.Shape) > 0 {
+		if shape := ExampleFeatureInt64List(example, p.Shape); len(shape) == 3 {
+			i.Height, i.Width, i.Channels = int(shape[0]), int(shape[1]), int(shape[2])
+		}
+	}
+
+	if len(i.Colorspace) == 0 {
+		switch i.channels() {
+		case 1:
+			i.Colorspace = "Gray"
+		case 4:
+			i.Colorspace = "RGBA"
+		default:
+			i.Colorspace = "RGB"
+		}
+	}
+	i.BitDepth = 8
+
+	if len(i.Raw) != i.Width*i.Height*i.channels() {
+		return fmt.Errorf("Invalid pixel data: got %d values for shape %dx%dx%d", len(i.Raw), i.Height, i.Width, i.channels())
+	}
+
+	return nil
+}
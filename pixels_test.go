@@ -0,0 +1,93 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRawPixels(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	for _, enc := range []PixelEncoding{PixelBytes, PixelInt64, PixelFloat} {
+		im, err := NewImage(bytes.NewReader(raw), 7, 1, 1, "Crystal", "test.jpg", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im.PixelEncoding = enc
+		if err := im.Convert(ConvertRaw, Resize(ResizeOptions{Width: 32, Height: 24})); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(im.Raw) != 32*24*3 {
+			t.Errorf("Incorrect pixel data size: got %d should be %d", len(im.Raw), 32*24*3)
+		}
+
+		ex, err := im.MarshalExample()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		shape := ExampleFeatureInt64List(ex, "image/shape")
+		if len(shape) != 3 || shape[0] != 24 || shape[1] != 32 || shape[2] != 3 {
+			t.Errorf("Incorrect shape: got %v should be %v", shape, []int{24, 32, 3})
+		}
+
+		img := &Image{}
+		if err := img.UnmarshalExample(ex); err != nil {
+			t.Fatal(err)
+		}
+
+		if img.PixelEncoding != enc || img.Format != RawFormat || !bytes.Equal(img.Raw, im.Raw) {
+			t.Errorf("Incorrect pixels for encoding %s", enc)
+		}
+
+		if err := img.Convert(ConvertPNG); err != nil {
+			t.Fatal(err)
+		}
+		if img.Format != "png" || img.Width != 32 || img.Height != 24 {
+			t.Errorf("Incorrect viewable image: got %s %dx%d", img.Format, img.Width, img.Height)
+		}
+	}
+}
+
+func TestRawImage(t *testing.T) {
+	im, err := rawImage([]byte{1, 2, 3, 4, 5, 6}, 2, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := color.NRGBAModel.Convert(im.At(1, 0)).(color.NRGBA); c != (color.NRGBA{R: 4, G: 5, B: 6, A: 255}) {
+		t.Errorf("Incorrect pixel: got %v", c)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 2, 2))
+	gray.SetGray(1, 1, color.Gray{Y: 9})
+	if raw := rawPixels(gray, true); !bytes.Equal(raw, []byte{0, 0, 0, 9}) {
+		t.Errorf("Incorrect gray pixels: got %v", raw)
+	}
+
+	if _, err := rawImage([]byte{1, 2, 3}, 2, 1, 3); err == nil {
+		t.Errorf("Expected error for short pixel data")
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile maps the fields of an Image to TensorFlow Example proto feature
+// keys. This allows reading and writing datasets created by other pipelines.
+// Fields with an empty key are not encoded.
+type Profile struct {
+	// Name of the profile
+	Name string
+
+	// Feature key for the unique ID of the image
+	ID string
+
+	// Encode the unique ID as a string feature instead of an integer. This is
+	// the convention used by image/source_id in the TF Object Detection API
+	StringID bool
+
+	// Feature key for the height in pixels
+	Height string
+
+	// Feature key for the width in pixels
+	Width string
+
+	// Feature key for the colorspace
+	Colorspace string
+
+	// Feature key for the number of channels
+	Channels string
+
+	// Feature key for the normalized label ID
+	Label string
+
+	// Feature key for the raw label ID
+	LabelRaw string
+
+	// Feature key for the source ID
+	Source string
+
+	// Feature key for the human-readable normalized label
+	Text string
+
+	// Feature key for the label confidences of multi-label images
+	Confidence string
+
+	// Feature key for the image format
+	Format string
+
+	// Feature key for the base filename
+	Filename string
+
+	// Feature key for the raw encoded image data
+	Encoded string
+
+	// Feature key for the [height, width, channels] shape of images stored as
+	// decoded pixels, see RawFormat
+	Shape string
+
+	// Feature key for the SHA-256 of the source image data
+	Key string
+
+	// Feature key for the path of the source image file
+	SourcePath string
+
+	// Feature key for the modification time of the source image file
+	ModTime string
+
+	// Feature key prefix for Extra metadata features
+	Meta string
+
+	// Feature key prefix for object bounding boxes. The keys follow the
+	// TensorFlow Object Detection API, for example image/object/bbox/xmin
+	// and image/object/class/label. Instance masks are stored under the mask
+	// key of this prefix
+	Object string
+
+	// Feature key prefix for the class segmentation mask. The encoded mask and
+	// its format are stored under the encoded and format keys of this prefix
+	Segmentation string
+}
+
+var (
+	// InceptionProfile is the layout used by the imagenet dataset from the
+	// inception research model in TensorFlow. This is the default profile.
+	InceptionProfile = &Profile{
+		Name:         "inception",
+		ID:           "image/id",
+		Height:       "image/height",
+		Width:        "image/width",
+		Colorspace:   "image/colorspace",
+		Channels:     "image/channels",
+		Label:        "image/class/label",
+		LabelRaw:     "image/class/raw",
+		Source:       "image/class/source",
+		Text:         "image/class/text",
+		Confidence:   "image/class/confidence",
+		Format:       "image/format",
+		Filename:     "image/filename",
+		Encoded:      "image/encoded",
+		Shape:        "image/shape",
+		Key:          "image/key/sha256",
+		SourcePath:   "image/source/path",
+		ModTime:      "image/source/mtime",
+		Meta:         MetaPrefix,
+		Object:       "image/object",
+		Segmentation: "image/segmentation/class",
+	}
+
+	// ObjectDetectionProfile is the layout used by the TensorFlow Object
+	// Detection API
+	ObjectDetectionProfile = &Profile{
+		Name:         "objdetect",
+		ID:           "image/source_id",
+		StringID:     true,
+		Height:       "image/height",
+		Width:        "image/width",
+		Label:        "image/class/label",
+		Text:         "image/class/text",
+		Format:       "image/format",
+		Filename:     "image/filename",
+		Encoded:      "image/encoded",
+		Shape:        "image/shape",
+		Key:          "image/key/sha256",
+		SourcePath:   "image/source/path",
+		ModTime:      "image/source/mtime",
+		Meta:         MetaPrefix,
+		Object:       "image/object",
+		Segmentation: "image/segmentation/class",
+	}
+
+	// TFDSProfile is the layout used by TensorFlow Datasets for image
+	// classification
+	TFDSProfile = &Profile{
+		Name:     "tfds",
+		Label:    "label",
+		Filename: "image/filename",
+		Encoded:  "image",
+	}
+
+	// Profiles are the named profiles accepted by ParseProfile
+	Profiles = map[string]*Profile{
+		InceptionProfile.Name:       InceptionProfile,
+		ObjectDetectionProfile.Name: ObjectDetectionProfile,
+		TFDSProfile.Name:            TFDSProfile,
+	}
+)
+
+// ParseProfile returns the Profile described by spec. spec is a comma
+// separated list starting with an optional profile name followed by any number
+// of field=key mappings which override the keys of the named profile. If no
+// name is given the mappings start from an empty profile. For example:
+//
+//  inception
+//  tfds,id=image/id
+//  encoded=image/raw,label=image/label
+//
+// Valid fields are: id, height, width, colorspace, channels, label, raw,
+// source, text, confidence, format, filename, encoded, shape, key, source_path,
+// mtime, meta, object, and segmentation. An empty spec returns
+// InceptionProfile. The returned Profile is a copy so changing it does not
+// change the named profiles.
+func ParseProfile(spec string) (*Profile, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		p := *InceptionProfile
+		return &p, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	p := &Profile{Name: "custom"}
+
+	if !strings.Contains(parts[0], "=") {
+		named, ok := Profiles[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("Unknown profile %s. Valid profiles are: %s", parts[0], strings.Join(ProfileNames(), ", "))
+		}
+		*p = *named
+		if len(parts) == 1 {
+			return p, nil
+		}
+
+		p.Name = named.Name + "-custom"
+		parts = parts[1:]
+	}
+
+	for _, m := range parts {
+		kv := strings.SplitN(m, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid profile mapping: %s", m)
+		}
+
+		key := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "id":
+			p.ID = key
+		case "height":
+			p.Height = key
+		case "width":
+			p.Width = key
+		case "colorspace":
+			p.Colorspace = key
+		case "channels":
+			p.Channels = key
+		case "label":
+			p.Label = key
+		case "raw":
+			p.LabelRaw = key
+		case "source":
+			p.Source = key
+		case "text":
+			p.Text = key
+		case "confidence":
+			p.Confidence = key
+		case "format":
+			p.Format = key
+		case "filename":
+			p.Filename = key
+		case "encoded":
+			p.Encoded = key
+		case "shape":
+			p.Shape = key
+		case "key":
+			p.Key = key
+		case "source_path":
+			p.SourcePath = key
+		case "mtime":
+			p.ModTime = key
+		case "meta":
+			p.Meta = key
+		case "object":
+			p.Object = key
+		case "segmentation":
+			p.Segmentation = key
+		default:
+			return nil, fmt.Errorf("Unknown profile field: %s", kv[0])
+		}
+	}
+
+	if len(p.Encoded) == 0 {
+		return nil, fmt.Errorf("Profile must map the encoded field")
+	}
+
+	return p, nil
+}
+
+// ProfileNames returns the sorted names of all registered profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
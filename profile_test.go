@@ -0,0 +1,105 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	p, err := ParseProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != *InceptionProfile {
+		t.Errorf("Incorrect default profile: got %s should be %s", p.Name, InceptionProfile.Name)
+	}
+
+	// Named profiles are returned as copies
+	p, err = ParseProfile("tfds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ID = "image/id"
+	if p == TFDSProfile || TFDSProfile.ID != "" {
+		t.Errorf("Named profile was modified")
+	}
+
+	p, err = ParseProfile("tfds,id=image/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != "image/id" || p.Encoded != TFDSProfile.Encoded {
+		t.Errorf("Incorrect custom profile: %+v", p)
+	}
+	if TFDSProfile.ID != "" {
+		t.Errorf("Named profile was modified")
+	}
+
+	if _, err := ParseProfile("bogus"); err == nil {
+		t.Errorf("Expected error for unknown profile")
+	}
+	if _, err := ParseProfile("label=foo"); err == nil {
+		t.Errorf("Expected error for profile without encoded field")
+	}
+	if _, err := ParseProfile("encoded=img,bogus=foo"); err == nil {
+		t.Errorf("Expected error for unknown field")
+	}
+}
+
+func TestProfileRoundTrip(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	for _, p := range []*Profile{InceptionProfile, ObjectDetectionProfile, TFDSProfile} {
+		im, err := NewImage(bytes.NewReader(raw), 1234, 1, 2, "Crystal", "test.jpg", 104)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im.Profile = p
+
+		example, err := im.MarshalExample()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := example.Features.Feature[p.Encoded]; !ok {
+			t.Errorf("%s: missing encoded feature %s", p.Name, p.Encoded)
+		}
+
+		img := &Image{Profile: p}
+		err = img.UnmarshalExample(example)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.LabelID != 1 {
+			t.Errorf("%s: incorrect label: got %d should be %d", p.Name, img.LabelID, 1)
+		}
+		if len(p.ID) > 0 && img.ID != 1234 {
+			t.Errorf("%s: incorrect id: got %d should be %d", p.Name, img.ID, 1234)
+		}
+		if img.Width != 150 || img.Height != 103 {
+			t.Errorf("%s: incorrect size: got %dx%d should be 150x103", p.Name, img.Width, img.Height)
+		}
+		if img.Format != "jpeg" {
+			t.Errorf("%s: incorrect format: got %s should be jpeg", p.Name, img.Format)
+		}
+	}
+}
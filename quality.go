@@ -0,0 +1,105 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Quality are image quality indicators used to find defective images such as
+// black or out-of-focus frames. Intensities are 8 bit grayscale luma values
+// (0-255).
+type Quality struct {
+	// Variance of the Laplacian of the image. Low values indicate a blurry
+	// image
+	Sharpness float64
+
+	// Mean intensity
+	Brightness float64
+
+	// Standard deviation of the intensity
+	Contrast float64
+
+	// Fraction of pixels that are black (0) or white (255)
+	Saturated float64
+
+	// Size in bytes of the encoded image
+	FileSize int
+}
+
+// MeasureQuality returns the Quality of the decoded image im. fileSize is the
+// size of the encoded image.
+func MeasureQuality(im image.Image, fileSize int) *Quality {
+	q := &Quality{FileSize: fileSize}
+
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return q
+	}
+
+	gray := make([]float64, w*h)
+	saturated := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := color.GrayModel.Convert(im.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			if v == 0 || v == 255 {
+				saturated++
+			}
+			gray[y*w+x] = float64(v)
+		}
+	}
+
+	q.Brightness, q.Contrast = meanStd(gray)
+	q.Saturated = float64(saturated) / float64(len(gray))
+
+	// 4-neighbour Laplacian of the interior pixels
+	if w > 2 && h > 2 {
+		lap := make([]float64, 0, (w-2)*(h-2))
+		for y := 1; y < h-1; y++ {
+			for x := 1; x < w-1; x++ {
+				n := y*w + x
+				lap = append(lap, gray[n-w]+gray[n+w]+gray[n-1]+gray[n+1]-4*gray[n])
+			}
+		}
+
+		_, std := meanStd(lap)
+		q.Sharpness = std * std
+	}
+
+	return q
+}
+
+// meanStd returns the mean and population standard deviation of vals
+func meanStd(vals []float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+
+	variance := 0.0
+	for _, v := range vals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(vals))
+
+	return mean, math.Sqrt(variance)
+}
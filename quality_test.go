@@ -0,0 +1,52 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMeasureQuality(t *testing.T) {
+	black := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	q := MeasureQuality(black, 100)
+	if q.Brightness != 0 || q.Contrast != 0 || q.Sharpness != 0 || q.Saturated != 1 || q.FileSize != 100 {
+		t.Errorf("Incorrect quality for black image: %+v", q)
+	}
+
+	checker := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetGray(x, y, color.Gray{Y: 100})
+			}
+		}
+	}
+
+	q = MeasureQuality(checker, 100)
+	if q.Brightness != 50 || q.Contrast != 50 || q.Saturated != 0.5 {
+		t.Errorf("Incorrect quality for checkerboard image: %+v", q)
+	}
+
+	// Laplacian is -400 or 400 on alternating pixels
+	if q.Sharpness != 160000 {
+		t.Errorf("Incorrect sharpness: got %f should be %f", q.Sharpness, 160000.0)
+	}
+}
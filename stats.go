@@ -0,0 +1,153 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// PixelStats are per-channel pixel statistics of a set of images. Pixel
+// values are 8 bit (0-255). The mean and variance are accumulated exactly per
+// image from its histogram and merged with the parallel algorithm of Chan et
+// al. so statistics computed concurrently and merged are numerically stable.
+type PixelStats struct {
+	// Number of channels. Grayscale images are expanded to three equal
+	// channels when Channels is 3 and color images are converted to luma when
+	// Channels is 1
+	Channels int
+
+	// Number of images
+	Images int
+
+	// Number of pixels per channel
+	Count int64
+
+	// Per-channel mean
+	Mean []float64
+
+	// Per-channel sum of squared differences from the mean
+	M2 []float64
+
+	// Per-channel histogram of pixel values
+	Histogram [][256]int64
+}
+
+// NewPixelStats returns new empty PixelStats for images with 1 or 3 channels
+func NewPixelStats(channels int) *PixelStats {
+	if channels != 1 {
+		channels = 3
+	}
+
+	return &PixelStats{
+		Channels:  channels,
+		Mean:      make([]float64, channels),
+		M2:        make([]float64, channels),
+		Histogram: make([][256]int64, channels),
+	}
+}
+
+// AddImage adds the pixels of im to the PixelStats s
+func (s *PixelStats) AddImage(im image.Image) {
+	stats := NewPixelStats(s.Channels)
+	stats.Images = 1
+
+	b := im.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := im.At(x, y)
+			if s.Channels == 1 {
+				stats.Histogram[0][color.GrayModel.Convert(c).(color.Gray).Y]++
+				continue
+			}
+
+			rgb := color.NRGBAModel.Convert(c).(color.NRGBA)
+			stats.Histogram[0][rgb.R]++
+			stats.Histogram[1][rgb.G]++
+			stats.Histogram[2][rgb.B]++
+		}
+	}
+
+	stats.Count = int64(b.Dx() * b.Dy())
+	if stats.Count == 0 {
+		s.Images++
+		return
+	}
+
+	// Two pass mean and variance from the histogram
+	for ch := range stats.Histogram {
+		sum := 0.0
+		for v, n := range stats.Histogram[ch] {
+			sum += float64(v) * float64(n)
+		}
+		stats.Mean[ch] = sum / float64(stats.Count)
+
+		for v, n := range stats.Histogram[ch] {
+			d := float64(v) - stats.Mean[ch]
+			stats.M2[ch] += d * d * float64(n)
+		}
+	}
+
+	s.Merge(stats)
+}
+
+// Merge adds the statistics in from to s. Both must have the same number of
+// channels
+func (s *PixelStats) Merge(from *PixelStats) {
+	s.Images += from.Images
+	if from.Count == 0 {
+		return
+	}
+
+	n := s.Count + from.Count
+	for ch := 0; ch < s.Channels; ch++ {
+		delta := from.Mean[ch] - s.Mean[ch]
+		s.Mean[ch] += delta * float64(from.Count) / float64(n)
+		s.M2[ch] += from.M2[ch] + delta*delta*float64(s.Count)*float64(from.Count)/float64(n)
+
+		for v := range s.Histogram[ch] {
+			s.Histogram[ch][v] += from.Histogram[ch][v]
+		}
+	}
+	s.Count = n
+}
+
+// Variance returns the per-channel population variance
+func (s *PixelStats) Variance() []float64 {
+	variance := make([]float64, s.Channels)
+	if s.Count == 0 {
+		return variance
+	}
+
+	for ch := range variance {
+		variance[ch] = s.M2[ch] / float64(s.Count)
+	}
+
+	return variance
+}
+
+// Std returns the per-channel population standard deviation
+func (s *PixelStats) Std() []float64 {
+	std := s.Variance()
+	for ch := range std {
+		std[ch] = math.Sqrt(std[ch])
+	}
+
+	return std
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestPixelStats(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	images := make([]*image.Gray, 4)
+	vals := make([]float64, 0)
+	for n := range images {
+		images[n] = image.NewGray(image.Rect(0, 0, 5+n, 3))
+		for idx := range images[n].Pix {
+			images[n].Pix[idx] = uint8(rnd.Intn(256))
+			vals = append(vals, float64(images[n].Pix[idx]))
+		}
+	}
+
+	mean := 0.0
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+
+	variance := 0.0
+	for _, v := range vals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(vals))
+
+	// Accumulate in two halves and merge as when reading shards in parallel
+	a := NewPixelStats(1)
+	b := NewPixelStats(1)
+	a.AddImage(images[0])
+	a.AddImage(images[1])
+	b.AddImage(images[2])
+	b.AddImage(images[3])
+	a.Merge(b)
+
+	if a.Images != 4 || a.Count != int64(len(vals)) {
+		t.Errorf("Incorrect counts: got %d images %d pixels", a.Images, a.Count)
+	}
+	if math.Abs(a.Mean[0]-mean) > 1e-9 {
+		t.Errorf("Incorrect mean: got %f should be %f", a.Mean[0], mean)
+	}
+	if math.Abs(a.Variance()[0]-variance) > 1e-9 {
+		t.Errorf("Incorrect variance: got %f should be %f", a.Variance()[0], variance)
+	}
+
+	total := int64(0)
+	for _, n := range a.Histogram[0] {
+		total += n
+	}
+	if total != a.Count {
+		t.Errorf("Incorrect histogram total: got %d should be %d", total, a.Count)
+	}
+}
+
+func TestPixelStatsRGB(t *testing.T) {
+	im := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	im.Set(0, 0, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	im.Set(1, 0, color.RGBA{R: 30, G: 20, B: 10, A: 255})
+
+	s := NewPixelStats(3)
+	s.AddImage(im)
+
+	expected := []float64{20, 20, 20}
+	for ch := range expected {
+		if s.Mean[ch] != expected[ch] {
+			t.Errorf("Incorrect mean for channel %d: got %f should be %f", ch, s.Mean[ch], expected[ch])
+		}
+	}
+
+	if std := s.Std(); std[0] != 10 || std[1] != 0 || std[2] != 10 {
+		t.Errorf("Incorrect std: got %v", std)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// tiffOrder returns the byte order of TIFF formatted data or nil if data is
+// not a TIFF
+func tiffOrder(data []byte) binary.ByteOrder {
+	if len(data) < 8 {
+		return nil
+	}
+
+	switch string(data[0:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+// tiffIFDs returns the offsets of the image file directories (pages) of TIFF
+// formatted data
+func tiffIFDs(data []byte) ([]uint32, error) {
+	order := tiffOrder(data)
+	if order == nil {
+		return nil, fmt.Errorf("Invalid TIFF header")
+	}
+
+	offsets := make([]uint32, 0)
+	seen := make(map[uint32]bool)
+	offset := order.Uint32(data[4:8])
+	for offset != 0 {
+		if seen[offset] {
+			return nil, fmt.Errorf("Invalid TIFF directory loop at offset %d", offset)
+		}
+		seen[offset] = true
+
+		if uint64(offset)+2 > uint64(len(data)) {
+			return nil, fmt.Errorf("Invalid TIFF directory offset %d", offset)
+		}
+
+		n := uint64(order.Uint16(data[offset : offset+2]))
+		next := uint64(offset) + 2 + n*12
+		if next+4 > uint64(len(data)) {
+			return nil, fmt.Errorf("Invalid TIFF directory size at offset %d", offset)
+		}
+
+		offsets = append(offsets, offset)
+		offset = order.Uint32(data[next : next+4])
+	}
+
+	return offsets, nil
+}
+
+// TIFFPages returns the number of pages in the TIFF formatted data
+func TIFFPages(data []byte) (int, error) {
+	offsets, err := tiffIFDs(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(offsets), nil
+}
+
+// tiffPage returns a copy of the TIFF formatted data with the header pointing
+// to page (starting at 0) so decoders read that page. The remaining pages are
+// left in place.
+func tiffPage(data []byte, page int) ([]byte, error) {
+	offsets, err := tiffIFDs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 0 || page >= len(offsets) {
+		return nil, fmt.Errorf("Invalid TIFF page %d: image has %d pages", page, len(offsets))
+	}
+
+	out := make([]byte, len(data))
+	copy(out, data)
+	tiffOrder(data).PutUint32(out[4:8], offsets[page])
+
+	return out, nil
+}
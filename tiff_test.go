@@ -0,0 +1,118 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+// multiTIFF returns an uncompressed 8-bit grayscale TIFF with one page for
+// each size
+func multiTIFF(sizes ...image.Point) []byte {
+	le := binary.LittleEndian
+	buf := new(bytes.Buffer)
+	buf.WriteString("II*\x00")
+	binary.Write(buf, le, uint32(8))
+
+	for n, sz := range sizes {
+		offset := uint32(buf.Len())
+		pixels := uint32(sz.X * sz.Y)
+		ifdSize := uint32(2 + 8*12 + 4)
+		tags := [][2]uint32{
+			{256, uint32(sz.X)},
+			{257, uint32(sz.Y)},
+			{258, 8},
+			{259, 1},
+			{262, 1},
+			{273, offset + ifdSize},
+			{278, uint32(sz.Y)},
+			{279, pixels},
+		}
+
+		binary.Write(buf, le, uint16(len(tags)))
+		for _, tag := range tags {
+			binary.Write(buf, le, uint16(tag[0]))
+			binary.Write(buf, le, uint16(exifLong))
+			binary.Write(buf, le, uint32(1))
+			binary.Write(buf, le, tag[1])
+		}
+
+		next := uint32(0)
+		if n < len(sizes)-1 {
+			next = offset + ifdSize + pixels
+		}
+		binary.Write(buf, le, next)
+		buf.Write(make([]byte, pixels))
+	}
+
+	return buf.Bytes()
+}
+
+func TestTIFFPages(t *testing.T) {
+	raw := multiTIFF(image.Pt(4, 2), image.Pt(3, 5))
+
+	n, err := TIFFPages(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Incorrect page count: got %d should be %d", n, 2)
+	}
+
+	for page, size := range []image.Point{{4, 2}, {3, 5}} {
+		im := &Image{Page: page}
+		if err := im.Read(bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+		if im.Format != "tiff" || im.Width != size.X || im.Height != size.Y || im.Colorspace != "Gray" {
+			t.Errorf("Invalid page %d: got %s %dx%d %s", page, im.Format, im.Width, im.Height, im.Colorspace)
+		}
+
+		if err := im.ToJPEG(); err != nil {
+			t.Fatal(err)
+		}
+		if im.Format != "jpeg" || im.Width != size.X || im.Height != size.Y {
+			t.Errorf("Invalid JPEG for page %d: got %s %dx%d", page, im.Format, im.Width, im.Height)
+		}
+	}
+
+	im := &Image{Page: 2}
+	if err := im.Read(bytes.NewReader(raw)); err == nil {
+		t.Errorf("Expected error for missing page")
+	}
+}
+
+func TestReadBMP(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := bmp.Encode(buf, image.NewRGBA(image.Rect(0, 0, 6, 3))); err != nil {
+		t.Fatal(err)
+	}
+
+	im := &Image{}
+	if err := im.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if im.Format != "bmp" || im.Width != 6 || im.Height != 3 {
+		t.Errorf("Invalid bmp image: got %s %dx%d", im.Format, im.Width, im.Height)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+const (
+	// TilePrefix is the Example feature key prefix for the position of a
+	// tile in its parent image: image/tile/parent_id, image/tile/x and
+	// image/tile/y
+	TilePrefix = "image/tile/"
+)
+
+// EdgePolicy determines how tiles that extend past the right or bottom edge
+// of an image are handled
+type EdgePolicy string
+
+const (
+	// EdgeDrop skips partial edge tiles
+	EdgeDrop EdgePolicy = "drop"
+
+	// EdgeKeep keeps partial edge tiles at their smaller size
+	EdgeKeep EdgePolicy = "keep"
+
+	// EdgePad pads partial edge tiles to the full tile size with the fill
+	// color
+	EdgePad EdgePolicy = "pad"
+
+	// EdgeShift moves edge tiles back inside the image so they overlap the
+	// previous tile. Images smaller than the tile are padded
+	EdgeShift EdgePolicy = "shift"
+)
+
+// ParseEdgePolicy returns the EdgePolicy with the given name. An empty name
+// returns EdgeDrop.
+func ParseEdgePolicy(name string) (EdgePolicy, error) {
+	switch e := EdgePolicy(strings.ToLower(name)); e {
+	case "":
+		return EdgeDrop, nil
+	case EdgeDrop, EdgeKeep, EdgePad, EdgeShift:
+		return e, nil
+	}
+
+	return "", fmt.Errorf("Unknown edge policy %s. Valid policies are: drop, keep, pad, shift", name)
+}
+
+// TileOptions are the options for splitting an image into tiles
+type TileOptions struct {
+	// Width and Height of the tiles in pixels
+	Width  int
+	Height int
+
+	// Horizontal and vertical distance in pixels between the top left corners
+	// of neighbouring tiles. A stride smaller than the tile size gives
+	// overlapping tiles. Defaults to the tile size
+	StrideX int
+	StrideY int
+
+	// Policy for tiles that extend past the image edge. Defaults to EdgeDrop
+	Edge EdgePolicy
+
+	// Fill color for padded tiles. Defaults to black
+	Fill color.Color
+}
+
+// TileInfo is the position of a tile in its parent image
+type TileInfo struct {
+	// ID of the parent image
+	ParentID int
+
+	// Top left corner of the tile in pixels relative to the upright parent
+	// image
+	X int
+	Y int
+}
+
+// tileStarts returns the start offsets of the tiles along one axis of length
+// size and whether the tile at each offset is padded
+func tileStarts(size, tile, stride int, edge EdgePolicy) ([]int, []bool) {
+	starts := make([]int, 0)
+	pads := make([]bool, 0)
+
+	next := 0
+	for ; next+tile <= size; next += stride {
+		starts = append(starts, next)
+		pads = append(pads, false)
+	}
+
+	// The last full tile reaches the edge or the rest of the image is covered
+	// by the previous tiles
+	if next >= size || (len(starts) > 0 && starts[len(starts)-1]+tile >= size) {
+		return starts, pads
+	}
+
+	switch edge {
+	case EdgeKeep:
+		starts = append(starts, next)
+		pads = append(pads, false)
+	case EdgePad:
+		starts = append(starts, next)
+		pads = append(pads, true)
+	case EdgeShift:
+		if size < tile {
+			starts = append(starts, 0)
+			pads = append(pads, true)
+		} else {
+			starts = append(starts, size-tile)
+			pads = append(pads, false)
+		}
+	}
+
+	return starts, pads
+}
+
+// Tiles decodes Image i, applies the transforms in order and splits the
+// result into tiles encoded for the conversion target c. Each tile is a copy
+// of Image i with Tile set to its position. Objects are transformed with the
+// image, clipped to each tile and marked truncated when clipped; objects
+// outside a tile are dropped along with their instance masks. Masks are
+// cropped to each tile. Key and Hashes describe the parent image and are
+// cleared.
+func (i *Image) Tiles(opts *TileOptions, c Conversion, transforms ...Transform) ([]*Image, error) {
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return nil, fmt.Errorf("Invalid tile size %dx%d", opts.Width, opts.Height)
+	}
+	if opts.StrideX < 0 || opts.StrideY < 0 {
+		return nil, fmt.Errorf("Invalid tile stride %dx%d", opts.StrideX, opts.StrideY)
+	}
+
+	strideX, strideY := opts.StrideX, opts.StrideY
+	if strideX == 0 {
+		strideX = opts.Width
+	}
+	if strideY == 0 {
+		strideY = opts.Height
+	}
+
+	edge := opts.Edge
+	if edge == "" {
+		edge = EdgeDrop
+	}
+
+	fill := opts.Fill
+	if fill == nil {
+		fill = color.Black
+	}
+
+	format, gray, err := i.encoding(c)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := *i
+	im, err := parent.transform(transforms)
+	if err != nil {
+		return nil, err
+	}
+
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	masks, err := parent.decodeMasks(w, h)
+	if err != nil {
+		return nil, err
+	}
+
+	xs, padX := tileStarts(w, opts.Width, strideX, edge)
+	ys, padY := tileStarts(h, opts.Height, strideY, edge)
+
+	tiles := make([]*Image, 0, len(xs)*len(ys))
+	for yi, y := range ys {
+		for xi, x := range xs {
+			r := image.Rect(x, y, x+opts.Width, y+opts.Height).Intersect(image.Rect(0, 0, w, h))
+			size := r.Size()
+			if padX[xi] {
+				size.X = opts.Width
+			}
+			if padY[yi] {
+				size.Y = opts.Height
+			}
+
+			tile := parent
+			tile.Tile = &TileInfo{ParentID: i.ID, X: x, Y: y}
+			tile.Key = ""
+			tile.Hashes = nil
+
+			err := tile.cropTile(masks, r, size, w, h)
+			if err != nil {
+				return nil, err
+			}
+
+			err = tile.encode(tileImage(im, r.Add(b.Min), size, image.NewUniform(fill)), format, gray)
+			if err != nil {
+				return nil, err
+			}
+
+			tiles = append(tiles, &tile)
+		}
+	}
+
+	return tiles, nil
+}
+
+// tileImage returns the portion of im inside r padded to size with fill. If
+// fill is nil the padding is zero, which is class 0 for masks. Paletted masks
+// keep their palette.
+func tileImage(im image.Image, r image.Rectangle, size image.Point, fill image.Image) image.Image {
+	sub := subImage(im, r)
+	if r.Size() == size {
+		return sub
+	}
+
+	var dst draw.Image
+	if p, ok := im.(*image.Paletted); ok {
+		dst = image.NewPaletted(image.Rect(0, 0, size.X, size.Y), p.Palette)
+	} else {
+		dst = newImageLike(im, image.Rect(0, 0, size.X, size.Y))
+	}
+	if fill != nil {
+		draw.Draw(dst, dst.Bounds(), fill, image.Point{}, draw.Src)
+	}
+	draw.Draw(dst, r.Sub(r.Min), sub, sub.Bounds().Min, draw.Src)
+
+	return dst
+}
+
+// decodeMasks decodes the class mask followed by the instance masks of Image
+// i and checks they are w x h pixels
+func (i *Image) decodeMasks(w, h int) ([]image.Image, error) {
+	raws := i.InstanceMasks
+	if len(i.Mask) > 0 {
+		raws = append([][]byte{i.Mask}, raws...)
+	}
+
+	masks := make([]image.Image, len(raws))
+	for n, raw := range raws {
+		m, err := png.Decode(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Bounds().Dx() != w || m.Bounds().Dy() != h {
+			return nil, fmt.Errorf("Mask size %dx%d does not match image size %dx%d", m.Bounds().Dx(), m.Bounds().Dy(), w, h)
+		}
+		masks[n] = m
+	}
+
+	return masks, nil
+}
+
+// cropTile clips the objects and crops the decoded masks of tile to the
+// rectangle r of the w x h parent image. The tile is size pixels after
+// padding.
+func (i *Image) cropTile(masks []image.Image, r image.Rectangle, size image.Point, w, h int) error {
+	var mask image.Image
+	instances := masks
+	if len(i.Mask) > 0 {
+		mask, instances = masks[0], masks[1:]
+	}
+
+	objects, kept := clipBoxes(i.Objects,
+		float64(r.Min.X)/float64(w), float64(r.Min.Y)/float64(h),
+		float64(r.Max.X)/float64(w), float64(r.Max.Y)/float64(h),
+		float64(size.X)/float64(w), float64(size.Y)/float64(h))
+	i.Objects = objects
+
+	var keep []image.Image
+	if len(instances) > 0 {
+		for _, n := range kept {
+			keep = append(keep, instances[n])
+		}
+	}
+
+	if mask != nil {
+		raw, err := encodeMask(tileImage(mask, r.Add(mask.Bounds().Min), size, nil))
+		if err != nil {
+			return err
+		}
+		i.Mask = raw
+	}
+
+	i.InstanceMasks = nil
+	for _, m := range keep {
+		raw, err := encodeMask(tileImage(m, r.Add(m.Bounds().Min), size, nil))
+		if err != nil {
+			return err
+		}
+		i.InstanceMasks = append(i.InstanceMasks, raw)
+	}
+
+	return nil
+}
+
+// encodeMask encodes a mask image as PNG
+func encodeMask(m image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshalTile adds the tile position features of Image i to features
+func (i *Image) marshalTile(features map[string]*protobuf.Feature) {
+	if i.Tile == nil {
+		return
+	}
+
+	features[TilePrefix+"parent_id"] = Int64Feature(int64(i.Tile.ParentID))
+	features[TilePrefix+"x"] = Int64Feature(int64(i.Tile.X))
+	features[TilePrefix+"y"] = Int64Feature(int64(i.Tile.Y))
+}
+
+// unmarshalTile decodes the tile position features of example into Image i
+func (i *Image) unmarshalTile(example *protobuf.Example) {
+	if _, ok := example.Features.Feature[TilePrefix+"x"]; !ok {
+		i.Tile = nil
+		return
+	}
+
+	i.Tile = &TileInfo{
+		ParentID: ExampleFeatureInt64(example, TilePrefix+"parent_id"),
+		X:        ExampleFeatureInt64(example, TilePrefix+"x"),
+		Y:        ExampleFeatureInt64(example, TilePrefix+"y"),
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"testing"
+)
+
+func TestTiles(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	tests := []struct {
+		opts  TileOptions
+		count int
+		last  [4]int
+	}{
+		{TileOptions{Width: 64, Height: 64}, 2, [4]int{64, 0, 64, 64}},
+		{TileOptions{Width: 64, Height: 64, Edge: EdgeKeep}, 6, [4]int{128, 64, 22, 39}},
+		{TileOptions{Width: 64, Height: 64, Edge: EdgePad}, 6, [4]int{128, 64, 64, 64}},
+		{TileOptions{Width: 64, Height: 64, Edge: EdgeShift}, 6, [4]int{86, 39, 64, 64}},
+		{TileOptions{Width: 64, Height: 64, StrideX: 32, StrideY: 32}, 6, [4]int{64, 32, 64, 64}},
+		{TileOptions{Width: 200, Height: 200}, 0, [4]int{}},
+	}
+
+	for _, test := range tests {
+		im, err := NewImage(bytes.NewReader(raw), 7, 1, 1, "Crystal", "test.jpg", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tiles, err := im.Tiles(&test.opts, ConvertNone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tiles) != test.count {
+			t.Errorf("Incorrect number of tiles for %+v: got %d should be %d", test.opts, len(tiles), test.count)
+			continue
+		}
+		if test.count == 0 {
+			continue
+		}
+
+		last := tiles[len(tiles)-1]
+		got := [4]int{last.Tile.X, last.Tile.Y, last.Width, last.Height}
+		if got != test.last {
+			t.Errorf("Incorrect last tile for %+v: got %v should be %v", test.opts, got, test.last)
+		}
+	}
+}
+
+func TestTileExample(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 7, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Box from (30, 10) to (90, 40) pixels crosses the first two tiles
+	im.Objects = []BBox{{XMin: 0.2, YMin: 10.0 / 103, XMax: 0.6, YMax: 40.0 / 103, LabelID: 1}}
+
+	tiles, err := im.Tiles(&TileOptions{Width: 75, Height: 50}, ConvertNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tiles) != 4 {
+		t.Fatalf("Incorrect number of tiles: got %d should be %d", len(tiles), 4)
+	}
+
+	for n, count := range []int{1, 1, 0, 0} {
+		if len(tiles[n].Objects) != count {
+			t.Errorf("Incorrect number of objects in tile %d: got %d should be %d", n, len(tiles[n].Objects), count)
+		}
+	}
+
+	box := tiles[1].Objects[0]
+	if box.XMin != 0 || !box.Truncated || math.Abs(box.XMax-15.0/75) > 1e-9 {
+		t.Errorf("Incorrect clipped box: got %+v", box)
+	}
+
+	ex, err := tiles[1].MarshalExample()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := ExampleFeatureInt64(ex, "image/tile/parent_id"); id != 7 {
+		t.Errorf("Incorrect parent id: got %d should be %d", id, 7)
+	}
+
+	img := &Image{}
+	if err := img.UnmarshalExample(ex); err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Tile == nil || *img.Tile != (TileInfo{ParentID: 7, X: 75, Y: 0}) {
+		t.Errorf("Incorrect tile: got %+v", img.Tile)
+	}
+	if name := img.Name(); name != "7_75_0.jpeg" {
+		t.Errorf("Incorrect name: got %s should be %s", name, "7_75_0.jpeg")
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Transform transforms decoded image data. Transforms are applied to an Image
+// with Image.Transform, Image.Convert or Image.ToJPEG.
+type Transform interface {
+	Apply(im image.Image) (image.Image, error)
+}
+
+// TransformFunc is a Transform implemented by a function
+type TransformFunc func(im image.Image) (image.Image, error)
+
+// Apply calls f(im)
+func (f TransformFunc) Apply(im image.Image) (image.Image, error) {
+	return f(im)
+}
+
+// Geometric is implemented by Transforms that move pixels, such as crops,
+// padding and resizing. Image.Convert and Image.Tiles apply them to the
+// bounding boxes and segmentation masks of the image along with the pixels.
+type Geometric interface {
+	Transform
+
+	// Boxes returns the bounding boxes of a w x h image after the transform
+	// and the indexes of the boxes that are kept. Boxes outside the
+	// transformed image are dropped.
+	Boxes(boxes []BBox, w, h int) ([]BBox, []int, error)
+
+	// Mask applies the transform to a segmentation mask of the image. Mask
+	// pixels are copied exactly with nearest neighbour resampling, padding
+	// is class 0 and paletted masks keep their palette.
+	Mask(m image.Image) (image.Image, error)
+}
+
+// layoutFunc returns the placement of a w x h image by a geometric transform:
+// the region src of the image is scaled into the region dst of an output
+// image of the given size. Rectangles are relative to the top left corner.
+type layoutFunc func(w, h int) (src, dst image.Rectangle, size image.Point, err error)
+
+// placement is a Geometric transform that crops, pads and scales an image
+// according to its layout. Pixels of the output image outside the
+// destination region are set to fill.
+type placement struct {
+	layout layoutFunc
+	filter draw.Interpolator
+	fill   color.Color
+}
+
+// Apply applies the placement to the pixels of im. Crops share the pixels of
+// im when possible.
+func (p *placement) Apply(im image.Image) (image.Image, error) {
+	b := im.Bounds()
+	src, dst, size, err := p.layout(b.Dx(), b.Dy())
+	if err != nil {
+		return nil, err
+	}
+
+	if src.Size() == size && dst == image.Rect(0, 0, size.X, size.Y) {
+		if src == image.Rect(0, 0, b.Dx(), b.Dy()) {
+			return im, nil
+		}
+
+		return subImage(im, src.Add(b.Min)), nil
+	}
+
+	out := newImageLike(im, image.Rect(0, 0, size.X, size.Y))
+	op := draw.Src
+	if dst.Size() != size {
+		draw.Draw(out, out.Bounds(), image.NewUniform(p.fill), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+
+	if src.Size() == dst.Size() {
+		draw.Draw(out, dst, im, src.Min.Add(b.Min), op)
+	} else {
+		p.filter.Scale(out, dst, im, src.Add(b.Min), op, nil)
+	}
+
+	return out, nil
+}
+
+// Boxes clips the boxes to the source region of the placement and maps them
+// into the destination region
+func (p *placement) Boxes(boxes []BBox, w, h int) ([]BBox, []int, error) {
+	src, dst, size, err := p.layout(w, h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fw, fh := float64(w), float64(h)
+	clipped, kept := clipBoxes(boxes,
+		float64(src.Min.X)/fw, float64(src.Min.Y)/fh,
+		float64(src.Max.X)/fw, float64(src.Max.Y)/fh,
+		float64(src.Dx())/fw, float64(src.Dy())/fh)
+
+	sx, sy := float64(size.X), float64(size.Y)
+	for n, c := range clipped {
+		c.XMin = (float64(dst.Min.X) + c.XMin*float64(dst.Dx())) / sx
+		c.YMin = (float64(dst.Min.Y) + c.YMin*float64(dst.Dy())) / sy
+		c.XMax = (float64(dst.Min.X) + c.XMax*float64(dst.Dx())) / sx
+		c.YMax = (float64(dst.Min.Y) + c.YMax*float64(dst.Dy())) / sy
+		clipped[n] = c
+	}
+
+	return clipped, kept, nil
+}
+
+// Mask applies the placement to the mask m with nearest neighbour resampling
+func (p *placement) Mask(m image.Image) (image.Image, error) {
+	b := m.Bounds()
+	src, dst, size, err := p.layout(b.Dx(), b.Dy())
+	if err != nil {
+		return nil, err
+	}
+
+	return remap(m, size.X, size.Y, func(x, y int) (int, int) {
+		if !image.Pt(x, y).In(dst) {
+			return -1, -1
+		}
+
+		// Sample the source pixel nearest to the center of (x, y)
+		sx := src.Min.X + (2*(x-dst.Min.X)+1)*src.Dx()/(2*dst.Dx())
+		sy := src.Min.Y + (2*(y-dst.Min.Y)+1)*src.Dy()/(2*dst.Dy())
+		return sx, sy
+	}), nil
+}
+
+// crop returns the layout for cropping to the rectangle returned by fn
+func crop(fn func(w, h int) (image.Rectangle, error)) layoutFunc {
+	return func(w, h int) (image.Rectangle, image.Rectangle, image.Point, error) {
+		r, err := fn(w, h)
+		if err != nil {
+			return image.Rectangle{}, image.Rectangle{}, image.Point{}, err
+		}
+
+		return r, r.Sub(r.Min), r.Size(), nil
+	}
+}
+
+// Filters are the resampling filters available for resizing
+var Filters = map[string]draw.Interpolator{
+	"nearest":        draw.NearestNeighbor,
+	"approxbilinear": draw.ApproxBiLinear,
+	"bilinear":       draw.BiLinear,
+	"catmullrom":     draw.CatmullRom,
+}
+
+// ParseFilter returns the resampling filter with the given name. An empty name
+// returns the default CatmullRom filter.
+func ParseFilter(name string) (draw.Interpolator, error) {
+	if len(name) == 0 {
+		return draw.CatmullRom, nil
+	}
+
+	f, ok := Filters[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(Filters))
+		for n := range Filters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown filter %s. Valid filters are: %s", name, strings.Join(names, ", "))
+	}
+
+	return f, nil
+}
+
+// ResizeOptions specify how an image is resized. Only one of MaxDim,
+// ShortSide or Width/Height should be set.
+type ResizeOptions struct {
+	// Scale the image so the longest side is at most MaxDim pixels preserving
+	// the aspect ratio. Smaller images are left unchanged.
+	MaxDim int
+
+	// Scale the image so the shortest side is ShortSide pixels preserving the
+	// aspect ratio
+	ShortSide int
+
+	// Scale the image to exactly Width x Height pixels. If only one of Width
+	// or Height is set the other is computed preserving the aspect ratio.
+	Width  int
+	Height int
+
+	// Resampling filter. Defaults to CatmullRom
+	Filter draw.Interpolator
+}
+
+// Size returns the resized dimensions of an image with width w and height h
+func (o *ResizeOptions) Size(w, h int) (int, int) {
+	if w == 0 || h == 0 {
+		return w, h
+	}
+
+	scale := func(s float64) (int, int) {
+		sw := int(math.Max(1, math.Round(float64(w)*s)))
+		sh := int(math.Max(1, math.Round(float64(h)*s)))
+		return sw, sh
+	}
+
+	switch {
+	case o.MaxDim > 0:
+		longest := w
+		if h > longest {
+			longest = h
+		}
+		if longest <= o.MaxDim {
+			return w, h
+		}
+		return scale(float64(o.MaxDim) / float64(longest))
+	case o.ShortSide > 0:
+		shortest := w
+		if h < shortest {
+			shortest = h
+		}
+		return scale(float64(o.ShortSide) / float64(shortest))
+	case o.Width > 0 && o.Height > 0:
+		return o.Width, o.Height
+	case o.Width > 0:
+		return scale(float64(o.Width) / float64(w))
+	case o.Height > 0:
+		return scale(float64(o.Height) / float64(h))
+	}
+
+	return w, h
+}
+
+// Resize returns a Transform that resizes an image according to opts
+func Resize(opts ResizeOptions) Transform {
+	filter := opts.Filter
+	if filter == nil {
+		filter = draw.CatmullRom
+	}
+
+	layout := func(w, h int) (image.Rectangle, image.Rectangle, image.Point, error) {
+		sw, sh := opts.Size(w, h)
+		return image.Rect(0, 0, w, h), image.Rect(0, 0, sw, sh), image.Pt(sw, sh), nil
+	}
+
+	return &placement{layout: layout, filter: filter}
+}
+
+// newImageLike returns a new image with bounds r using a color model suitable
+// for the pixels of im
+func newImageLike(im image.Image, r image.Rectangle) draw.Image {
+	switch im.(type) {
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.RGBA64, *image.NRGBA64:
+		return image.NewRGBA64(r)
+	}
+
+	return image.NewRGBA(r)
+}
+
+// Crop returns a Transform that crops an image to the rectangle r. The
+// coordinates of r are relative to the top left corner of the image. r is
+// clipped to the image bounds.
+func Crop(r image.Rectangle) Transform {
+	return &placement{layout: crop(func(w, h int) (image.Rectangle, error) {
+		b := image.Rect(0, 0, w, h)
+		c := r.Intersect(b)
+		if c.Empty() {
+			return c, fmt.Errorf("Crop box %v is outside image bounds %v", r, b)
+		}
+
+		return c, nil
+	})}
+}
+
+// CenterCrop returns a Transform that crops a w x h rectangle from the center
+// of an image. Images smaller than w or h are only cropped in the other
+// dimension.
+func CenterCrop(w, h int) Transform {
+	return &placement{layout: crop(func(iw, ih int) (image.Rectangle, error) {
+		return centerRect(iw, ih, w, h), nil
+	})}
+}
+
+// centerRect returns the w x h rectangle at the center of an iw x ih image.
+// Sizes that are not positive or larger than the image are set to the image
+// size.
+func centerRect(iw, ih, w, h int) image.Rectangle {
+	if w <= 0 || w > iw {
+		w = iw
+	}
+	if h <= 0 || h > ih {
+		h = ih
+	}
+
+	x := (iw - w) / 2
+	y := (ih - h) / 2
+
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// CenterCropFraction returns a Transform that crops the central fraction of
+// an image in each dimension. For example, 0.8 keeps the central 80% of the
+// width and height.
+func CenterCropFraction(fraction float64) Transform {
+	return &placement{layout: crop(func(iw, ih int) (image.Rectangle, error) {
+		if fraction <= 0 || fraction > 1 {
+			return image.Rectangle{}, fmt.Errorf("Invalid crop fraction %f, must be in (0, 1]", fraction)
+		}
+
+		w := int(math.Max(1, math.Round(float64(iw)*fraction)))
+		h := int(math.Max(1, math.Round(float64(ih)*fraction)))
+
+		return centerRect(iw, ih, w, h), nil
+	})}
+}
+
+// PadSquare returns a Transform that pads an image to a square with the
+// fill color. The image is centered in the square. A nil fill is black.
+func PadSquare(fill color.Color) Transform {
+	if fill == nil {
+		fill = color.Black
+	}
+
+	layout := func(w, h int) (image.Rectangle, image.Rectangle, image.Point, error) {
+		size := w
+		if h > size {
+			size = h
+		}
+
+		src := image.Rect(0, 0, w, h)
+		offset := image.Pt((size-w)/2, (size-h)/2)
+
+		return src, src.Add(offset), image.Pt(size, size), nil
+	}
+
+	return &placement{layout: layout, fill: fill}
+}
+
+// subImage returns the portion of im inside r. The pixels are shared with im
+// when possible.
+func subImage(im image.Image, r image.Rectangle) image.Image {
+	if s, ok := im.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+
+	dst := newImageLike(im, image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), im, r.Min, draw.Src)
+
+	return dst
+}
+
+// ParseRect parses a rectangle in the form xmin,ymin,xmax,ymax
+func ParseRect(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("Invalid rectangle %s, should be xmin,ymin,xmax,ymax", s)
+	}
+
+	vals := make([]int, 4)
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("Invalid rectangle %s, should be xmin,ymin,xmax,ymax", s)
+		}
+		vals[i] = n
+	}
+
+	r := image.Rect(vals[0], vals[1], vals[2], vals[3])
+	if r.Empty() {
+		return r, fmt.Errorf("Empty rectangle %s", s)
+	}
+
+	return r, nil
+}
+
+// FormatRect formats a rectangle in the form xmin,ymin,xmax,ymax. This is the
+// inverse of ParseRect
+func FormatRect(r image.Rectangle) string {
+	return fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+}
+
+// ParseColor parses a hex color in the form #rrggbb or #rgb. The leading # is
+// optional.
+func ParseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("Invalid color %s, should be #rrggbb", s)
+	}
+
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid color %s, should be #rrggbb", s)
+	}
+
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
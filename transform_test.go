@@ -0,0 +1,184 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestResizeSize(t *testing.T) {
+	tests := []struct {
+		opts ResizeOptions
+		w, h int
+	}{
+		{ResizeOptions{}, 150, 100},
+		{ResizeOptions{MaxDim: 75}, 75, 50},
+		{ResizeOptions{MaxDim: 300}, 150, 100},
+		{ResizeOptions{ShortSide: 50}, 75, 50},
+		{ResizeOptions{ShortSide: 200}, 300, 200},
+		{ResizeOptions{Width: 64, Height: 64}, 64, 64},
+		{ResizeOptions{Width: 30}, 30, 20},
+		{ResizeOptions{Height: 10}, 15, 10},
+	}
+
+	for _, test := range tests {
+		w, h := test.opts.Size(150, 100)
+		if w != test.w || h != test.h {
+			t.Errorf("Incorrect size for %+v: got %dx%d should be %dx%d", test.opts, w, h, test.w, test.h)
+		}
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = im.ToJPEG(Resize(ResizeOptions{MaxDim: 75}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if im.Width != 75 || im.Height != 52 {
+		t.Errorf("Incorrect size: got %dx%d should be 75x52", im.Width, im.Height)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(im.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format != "jpeg" || cfg.Width != im.Width || cfg.Height != im.Height {
+		t.Errorf("Incorrect encoded image: got %s %dx%d should be jpeg %dx%d", format, cfg.Width, cfg.Height, im.Width, im.Height)
+	}
+}
+
+func TestCropPad(t *testing.T) {
+	im := image.NewGray(image.Rect(0, 0, 100, 60))
+
+	tests := []struct {
+		name string
+		t    Transform
+		w, h int
+	}{
+		{"crop", Crop(image.Rect(10, 10, 40, 30)), 30, 20},
+		{"crop clipped", Crop(image.Rect(90, 50, 120, 80)), 10, 10},
+		{"center crop", CenterCrop(50, 50), 50, 50},
+		{"center crop larger", CenterCrop(200, 20), 100, 20},
+		{"center crop fraction", CenterCropFraction(0.5), 50, 30},
+		{"pad square", PadSquare(color.White), 100, 100},
+	}
+
+	for _, test := range tests {
+		out, err := test.t.Apply(im)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		b := out.Bounds()
+		if b.Dx() != test.w || b.Dy() != test.h {
+			t.Errorf("%s: incorrect size: got %dx%d should be %dx%d", test.name, b.Dx(), b.Dy(), test.w, test.h)
+		}
+	}
+
+	padded, _ := PadSquare(color.White).Apply(im)
+	if g := padded.(*image.Gray).GrayAt(50, 5).Y; g != 0xff {
+		t.Errorf("Incorrect pad color: got %d should be %d", g, 0xff)
+	}
+	if g := padded.(*image.Gray).GrayAt(50, 50).Y; g != 0 {
+		t.Errorf("Incorrect image pixel: got %d should be %d", g, 0)
+	}
+
+	padded, err := PadSquare(nil).Apply(im)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := padded.(*image.Gray).GrayAt(50, 5).Y; g != 0 {
+		t.Errorf("Incorrect default pad color: got %d should be %d", g, 0)
+	}
+
+	if _, err := Crop(image.Rect(200, 200, 300, 300)).Apply(im); err == nil {
+		t.Errorf("Expected error for crop outside bounds")
+	}
+}
+
+func TestTransformBoxes(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 7, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im.Objects = []BBox{
+		{XMin: 0, YMin: 0, XMax: 0.5, YMax: 0.5},
+		{XMin: 0.6, YMin: 0.6, XMax: 1, YMax: 1},
+	}
+
+	// The right half of the 150x103 image is padded to 103x103 with a 14
+	// pixel border on the left and right
+	err = im.Convert(ConvertPNG, Crop(image.Rect(75, 0, 150, 103)), PadSquare(color.Black), Resize(ResizeOptions{MaxDim: 50}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if im.Width != 50 || im.Height != 50 {
+		t.Errorf("Incorrect size: got %dx%d should be 50x50", im.Width, im.Height)
+	}
+
+	expected := BBox{XMin: 29.0 / 103, YMin: 0.6, XMax: 89.0 / 103, YMax: 1}
+	if len(im.Objects) != 1 {
+		t.Fatalf("Incorrect number of objects: got %d should be 1", len(im.Objects))
+	}
+	got := im.Objects[0]
+	if d := math.Abs(got.XMin-expected.XMin) + math.Abs(got.YMin-expected.YMin) + math.Abs(got.XMax-expected.XMax) + math.Abs(got.YMax-expected.YMax); d > 1e-9 {
+		t.Errorf("Incorrect box: got %+v should be %+v", got, expected)
+	}
+}
+
+func TestParseRectColor(t *testing.T) {
+	r, err := ParseRect("1, 2,30,40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != image.Rect(1, 2, 30, 40) {
+		t.Errorf("Incorrect rectangle: got %v", r)
+	}
+	if FormatRect(r) != "1,2,30,40" {
+		t.Errorf("Incorrect format: got %s", FormatRect(r))
+	}
+
+	c, err := ParseColor("#ff8000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != (color.RGBA{R: 0xff, G: 0x80, B: 0, A: 0xff}) {
+		t.Errorf("Incorrect color: got %v", c)
+	}
+
+	if _, err := ParseColor("#12345"); err == nil {
+		t.Errorf("Expected error for invalid color")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions maps lower case file extensions to the image format names
+// returned by image.DecodeConfig
+var Extensions = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".jpe":  "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".tif":  "tiff",
+	".tiff": "tiff",
+	".bmp":  "bmp",
+	".webp": "webp",
+}
+
+// jpegEOI is the JPEG end of image marker
+var jpegEOI = []byte{0xff, 0xd9}
+
+// Validate fully decodes the raw image data and returns an error describing
+// the problem if the image is empty, has zero width or height, is a truncated
+// JPEG, fails to decode, or if the extension of Filename does not match the
+// image format. Unknown extensions are not checked.
+func (i *Image) Validate() error {
+	if len(i.Raw) == 0 {
+		return errors.New("Empty image data")
+	}
+
+	if i.Width == 0 || i.Height == 0 {
+		return fmt.Errorf("Zero size image %dx%d", i.Width, i.Height)
+	}
+
+	ext := strings.ToLower(filepath.Ext(i.Filename))
+	if format, ok := Extensions[ext]; ok && format != i.Format {
+		return fmt.Errorf("File extension %s does not match image format %s", ext, i.Format)
+	}
+
+	if i.Format == "jpeg" && bytes.LastIndex(i.Raw, jpegEOI) < 0 {
+		return errors.New("Truncated JPEG: missing end of image marker")
+	}
+
+	im, _, err := image.Decode(bytes.NewReader(i.Raw))
+	if err != nil {
+		return fmt.Errorf("Failed to decode image: %s", err)
+	}
+
+	if im.Bounds().Empty() {
+		return errors.New("Zero size image")
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(data)
+
+	im, err := NewImage(bytes.NewReader(raw), 1, 1, 1, "Crystal", "test.jpg", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Validate(); err != nil {
+		t.Errorf("Expected valid image: got %s", err)
+	}
+
+	im.Filename = "test.png"
+	if err := im.Validate(); err == nil {
+		t.Errorf("Expected error for extension mismatch")
+	}
+
+	for _, n := range []int{len(raw) / 2, len(raw) - 2} {
+		im, err := NewImage(bytes.NewReader(raw[:n]), 1, 1, 1, "Crystal", "test.jpg", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := im.Validate(); err == nil {
+			t.Errorf("Expected error for JPEG truncated to %d bytes", n)
+		}
+	}
+
+	im = &Image{Raw: raw, Format: "jpeg"}
+	if err := im.Validate(); err == nil {
+		t.Errorf("Expected error for zero size image")
+	}
+}
@@ -36,15 +36,22 @@ var (
 	crc32c = crc32.MakeTable(crc32.Castagnoli)
 )
 
-// Writer implements a writer for TFRecords with Example protos
+// Writer implements a writer for TFRecords with Example protos. Example protos
+// are marshalled deterministically with feature keys in sorted order so
+// writing the same Examples always produces byte-identical output.
 type Writer struct {
 	writer *bufio.Writer
+	buf    *proto.Buffer
 }
 
 // NewWriter returns a new Writer
 func NewWriter(w io.Writer) *Writer {
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+
 	return &Writer{
 		writer: bufio.NewWriter(w),
+		buf:    buf,
 	}
 }
 
@@ -74,10 +81,12 @@ func (w *Writer) Write(ex *protobuf.Example) error {
 	//  byte      data[length]
 	//  uint32    masked crc of data
 
-	payload, err := proto.Marshal(ex)
+	w.buf.Reset()
+	err := w.buf.Marshal(ex)
 	if err != nil {
 		return err
 	}
+	payload := w.buf.Bytes()
 
 	length := len(payload)
 	header := make([]byte, 12)
@@ -0,0 +1,58 @@
+// Copyright 2018 terf Authors. All rights reserved.
+//
+// This file is part of terf.
+//
+// terf is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// terf is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with terf.  If not, see <http://www.gnu.org/licenses/>.
+
+package terf
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	protobuf "github.com/ubccr/terf/protobuf"
+)
+
+func TestDeterministicWrite(t *testing.T) {
+	var first []byte
+
+	for n := 0; n < 10; n++ {
+		features := make(map[string]*protobuf.Feature)
+		for k := 0; k < 50; k++ {
+			features[fmt.Sprintf("image/meta/key%d", k)] = Int64Feature(int64(k))
+		}
+
+		output := new(bytes.Buffer)
+		w := NewWriter(output)
+		err := w.Write(&protobuf.Example{Features: &protobuf.Features{Feature: features}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Flush()
+		if err := w.Error(); err != nil {
+			t.Fatal(err)
+		}
+
+		if first == nil {
+			first = output.Bytes()
+			continue
+		}
+
+		if !bytes.Equal(first, output.Bytes()) {
+			t.Fatalf("Output differs on write %d", n)
+		}
+	}
+}